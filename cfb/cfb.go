@@ -0,0 +1,117 @@
+// Package cfb reads and writes compound files (MS-CFB), the container of binary Office files (.xls, .doc) and vbaProject.bin.
+// A compound file is read completely into memory as a tree of storages and streams, which can be modified and written again.
+// Unlike github.com/richardlehane/mscfb, class IDs, state bits and timestamps of the entries are preserved.
+package cfb
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	ErrFormat  = errors.New("cfb: not a compound file")
+	ErrCorrupt = errors.New("cfb: corrupt compound file")
+)
+
+// Storage of a compound file, the root storage represents the file
+type Storage struct {
+	Name      string
+	CLSID     [16]byte
+	StateBits uint32
+	Created   uint64 // FILETIME
+	Modified  uint64 // FILETIME
+	Storages  []*Storage
+	Streams   []*Stream
+}
+
+// Stream of a compound file
+type Stream struct {
+	Name string
+	Data []byte
+}
+
+const (
+	signature      = "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"
+	headerSize     = 512
+	dirEntrySize   = 128
+	miniSectorSize = 64
+	miniCutoff     = 4096
+	maxNameLength  = 31 // characters, without the terminating null character
+	headerDifats   = 109
+
+	// Special sector numbers
+	difSect    uint32 = 0xFFFFFFFC
+	fatSect    uint32 = 0xFFFFFFFD
+	endOfChain uint32 = 0xFFFFFFFE
+	freeSect   uint32 = 0xFFFFFFFF
+	noStream   uint32 = 0xFFFFFFFF
+
+	// Object types of directory entries
+	typeStorage byte = 1
+	typeStream  byte = 2
+	typeRoot    byte = 5
+)
+
+// Reports whether the data starts with the signature of a compound file
+func IsCompoundFile(header []byte) bool {
+	return len(header) >= len(signature) && string(header[:len(signature)]) == signature
+}
+
+// Returns the child storage with the given name (case-insensitive like all names in compound files), nil if not present
+func (s *Storage) Storage(name string) *Storage {
+	for _, child := range s.Storages {
+		if equalNames(child.Name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Returns the stream with the given name, nil if not present
+func (s *Storage) Stream(name string) *Stream {
+	for _, stream := range s.Streams {
+		if equalNames(stream.Name, name) {
+			return stream
+		}
+	}
+	return nil
+}
+
+// Sets the content of a stream, the stream is added if not present
+func (s *Storage) SetStream(name string, data []byte) {
+	if stream := s.Stream(name); stream != nil {
+		stream.Data = data
+		return
+	}
+	s.Streams = append(s.Streams, &Stream{Name: name, Data: data})
+}
+
+// Removes a stream, returns false if the stream is not present
+func (s *Storage) RemoveStream(name string) bool {
+	for i, stream := range s.Streams {
+		if equalNames(stream.Name, name) {
+			s.Streams = append(s.Streams[:i], s.Streams[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func equalNames(a string, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// Order of entries in the directory tree: shorter names first, then by the upper case names
+func compareNames(a string, b string) int {
+	ua, ub := utf16.Encode([]rune(strings.ToUpper(a))), utf16.Encode([]rune(strings.ToUpper(b)))
+	if len(ua) != len(ub) {
+		return len(ua) - len(ub)
+	}
+	for i := range ua {
+		if ua[i] != ub[i] {
+			return int(ua[i]) - int(ub[i])
+		}
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package cfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"unicode"
+
+	"github.com/richardlehane/mscfb"
+)
+
+func testData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// Storage with streams on both sides of the mini stream cutoff, nested storages and metadata
+func testStorage() *Storage {
+	root := &Storage{CLSID: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}}
+	for i, size := range []int{0, 1, 63, 64, 65, miniCutoff - 1, miniCutoff, miniCutoff + 1, 3*sectorSize + 1, 100000} {
+		root.SetStream(fmt.Sprintf("stream%d", size), testData(int64(i), size))
+	}
+	vba := &Storage{Name: "_VBA_PROJECT_CUR", StateBits: 7, Created: 0x01D0000000000001, Modified: 0x01D0000000000002}
+	vba.SetStream("PROJECT", []byte("ID=\"{00000000-0000-0000-0000-000000000000}\"\r\n"))
+	vba.SetStream("\x05DigitalSignatureExt", testData(20, 5000))
+	sub := &Storage{Name: "VBA", CLSID: [16]byte{0xFF}}
+	sub.SetStream("dir", testData(21, 700))
+	sub.SetStream("Module1", testData(22, miniCutoff))
+	vba.Storages = append(vba.Storages, sub)
+	root.Storages = append(root.Storages, vba, &Storage{Name: "empty"})
+	return root
+}
+
+func writeStorage(t *testing.T, s *Storage) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := s.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || buf.Len()%sectorSize != 0 {
+		t.Fatalf("wrote %d bytes, reported %d, want a multiple of %d", buf.Len(), n, sectorSize)
+	}
+	return buf.Bytes()
+}
+
+// Streams by path, e.g. _VBA_PROJECT_CUR/VBA/dir
+func streams(s *Storage, prefix string, res map[string][]byte) map[string][]byte {
+	for _, stream := range s.Streams {
+		res[prefix+stream.Name] = stream.Data
+	}
+	for _, child := range s.Storages {
+		streams(child, prefix+child.Name+"/", res)
+	}
+	return res
+}
+
+func compareStreams(t *testing.T, reader string, got map[string][]byte, want map[string][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %d streams, want %d", reader, len(got), len(want))
+	}
+	for name, data := range want {
+		if g, ok := got[name]; !ok {
+			t.Errorf("%s: stream %q missing", reader, name)
+		} else if !bytes.Equal(g, data) {
+			t.Errorf("%s: stream %q has %d bytes, want %d bytes", reader, name, len(g), len(data))
+		}
+	}
+}
+
+// Reads all streams with github.com/richardlehane/mscfb, the reader used by ParseVbaProject
+func readMscfb(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	doc, err := mscfb.New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string][]byte{}
+	for entry, err := doc.Next(); err != io.EOF; entry, err = doc.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		content, err := io.ReadAll(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// mscfb strips a non-printable first character (e.g. \x05 of property set streams) from the name
+		name := entry.Name
+		if !unicode.IsPrint(rune(entry.Initial)) {
+			name = string(rune(entry.Initial)) + name
+		}
+		for i := len(entry.Path) - 1; i >= 0; i-- {
+			name = entry.Path[i] + "/" + name
+		}
+		res[name] = content
+	}
+	return res
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testStorage()
+	data := writeStorage(t, want)
+	got, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareStreams(t, "cfb", streams(got, "", map[string][]byte{}), streams(want, "", map[string][]byte{}))
+	compareStreams(t, "mscfb", readMscfb(t, data), streams(want, "", map[string][]byte{}))
+
+	// Metadata of the storages is preserved
+	if got.CLSID != want.CLSID {
+		t.Errorf("root CLSID %X, want %X", got.CLSID, want.CLSID)
+	}
+	vba, wantVba := got.Storage("_vba_project_cur"), want.Storage("_VBA_PROJECT_CUR")
+	if vba == nil || vba.Storage("VBA") == nil || got.Storage("empty") == nil {
+		t.Fatal("storages missing")
+	}
+	if vba.Name != wantVba.Name || vba.StateBits != wantVba.StateBits || vba.Created != wantVba.Created || vba.Modified != wantVba.Modified {
+		t.Errorf("storage %q (state %d, created %X, modified %X), want %q (state %d, created %X, modified %X)", vba.Name, vba.StateBits,
+			vba.Created, vba.Modified, wantVba.Name, wantVba.StateBits, wantVba.Created, wantVba.Modified)
+	}
+	if vba.Storage("VBA").CLSID != wantVba.Storage("VBA").CLSID {
+		t.Errorf("CLSID of VBA %X, want %X", vba.Storage("VBA").CLSID, wantVba.Storage("VBA").CLSID)
+	}
+
+	// Writing what was read gives the same file
+	if again := writeStorage(t, got); !bytes.Equal(again, data) {
+		t.Error("rewritten compound file differs")
+	}
+}
+
+func TestRoundTripDIFAT(t *testing.T) {
+	// More than 109 FAT sectors (109 * 128 sectors of 512 bytes) need one DIFAT sector, more than 236 a second one
+	for _, test := range []struct {
+		size         int
+		difatSectors uint32
+	}{
+		{7 << 20, 1},
+		{15 << 20, 2},
+	} {
+		t.Run(fmt.Sprintf("%d MiB", test.size>>20), func(t *testing.T) {
+			want := &Storage{}
+			want.SetStream("large", testData(1, test.size))
+			want.SetStream("small", testData(2, 100))
+			data := writeStorage(t, want)
+			if n := binary.LittleEndian.Uint32(data[72:]); n != test.difatSectors {
+				t.Fatalf("%d DIFAT sectors, want %d", n, test.difatSectors)
+			}
+			got, err := Read(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareStreams(t, "cfb", streams(got, "", map[string][]byte{}), streams(want, "", map[string][]byte{}))
+			compareStreams(t, "mscfb", readMscfb(t, data), streams(want, "", map[string][]byte{}))
+		})
+	}
+}
+
+func TestWriteInvalidName(t *testing.T) {
+	for _, name := range []string{"", "a/b", "a name longer than thirty-one characters"} {
+		s := &Storage{}
+		s.SetStream(name, []byte("data"))
+		if _, err := s.WriteTo(io.Discard); err == nil {
+			t.Errorf("stream name %q accepted", name)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	data := writeStorage(t, testStorage())
+	if _, err := Read(bytes.NewReader(data[:100]), 100); !errors.Is(err, ErrFormat) {
+		t.Errorf("got error %v for a truncated header, want ErrFormat", err)
+	}
+	if _, err := Read(bytes.NewReader([]byte("PK\x03\x04")), 4); !errors.Is(err, ErrFormat) {
+		t.Errorf("got error %v for a zip file, want ErrFormat", err)
+	}
+	if _, err := Read(bytes.NewReader(data[:2*sectorSize]), 2*sectorSize); err == nil {
+		t.Error("truncated compound file accepted")
+	}
+}
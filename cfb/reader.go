@@ -0,0 +1,273 @@
+package cfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf16"
+)
+
+type reader struct {
+	data        []byte
+	sectorSize  int
+	majorVer    uint16
+	fat         []uint32
+	miniFat     []uint32
+	miniStream  []byte
+	entries     []dirEntry
+	miniCutoff  uint32
+	visitedDirs []bool
+}
+
+type dirEntry struct {
+	name       string
+	objectType byte
+	left       uint32
+	right      uint32
+	child      uint32
+	clsid      [16]byte
+	stateBits  uint32
+	created    uint64
+	modified   uint64
+	start      uint32
+	size       uint64
+}
+
+// Reads a compound file of the given size, the returned root storage contains all storages and streams
+func Read(r io.ReaderAt, size int64) (*Storage, error) {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize || !IsCompoundFile(data) {
+		return nil, ErrFormat
+	}
+	rd := reader{data: data}
+	if err = rd.readHeader(); err != nil {
+		return nil, err
+	}
+	return rd.readTree()
+}
+
+func (rd *reader) readHeader() error {
+	h := rd.data[:headerSize]
+	rd.majorVer = binary.LittleEndian.Uint16(h[26:])
+	sectorShift := binary.LittleEndian.Uint16(h[30:])
+	switch {
+	case rd.majorVer == 3 && sectorShift == 9, rd.majorVer == 4 && sectorShift == 12:
+		rd.sectorSize = 1 << sectorShift
+	default:
+		return fmt.Errorf("%w: version %d with sector shift %d", ErrFormat, rd.majorVer, sectorShift)
+	}
+	if binary.LittleEndian.Uint16(h[32:]) != 6 {
+		return fmt.Errorf("%w: unsupported mini sector size", ErrCorrupt)
+	}
+	numFatSectors := binary.LittleEndian.Uint32(h[44:])
+	firstDirSector := binary.LittleEndian.Uint32(h[48:])
+	rd.miniCutoff = binary.LittleEndian.Uint32(h[56:])
+	firstMiniFatSector := binary.LittleEndian.Uint32(h[60:])
+	firstDifatSector := binary.LittleEndian.Uint32(h[68:])
+	numDifatSectors := binary.LittleEndian.Uint32(h[72:])
+
+	// Locations of the FAT sectors, from the header and the DIFAT sectors
+	if uint64(numFatSectors)*uint64(rd.sectorSize) > uint64(len(rd.data)) {
+		return fmt.Errorf("%w: %d FAT sectors exceed the file size", ErrCorrupt, numFatSectors)
+	}
+	fatSectors := make([]uint32, 0, numFatSectors)
+	for i := 0; i < headerDifats && uint32(len(fatSectors)) < numFatSectors; i++ {
+		fatSectors = append(fatSectors, binary.LittleEndian.Uint32(h[76+4*i:]))
+	}
+	difatSector := firstDifatSector
+	for i := uint32(0); i < numDifatSectors && uint32(len(fatSectors)) < numFatSectors; i++ {
+		sector, err := rd.sector(difatSector)
+		if err != nil {
+			return err
+		}
+		perSector := rd.sectorSize/4 - 1
+		for j := 0; j < perSector && uint32(len(fatSectors)) < numFatSectors; j++ {
+			fatSectors = append(fatSectors, binary.LittleEndian.Uint32(sector[4*j:]))
+		}
+		difatSector = binary.LittleEndian.Uint32(sector[4*perSector:])
+	}
+	if uint32(len(fatSectors)) != numFatSectors {
+		return fmt.Errorf("%w: DIFAT incomplete", ErrCorrupt)
+	}
+	for _, fs := range fatSectors {
+		sector, err := rd.sector(fs)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < rd.sectorSize; j += 4 {
+			rd.fat = append(rd.fat, binary.LittleEndian.Uint32(sector[j:]))
+		}
+	}
+
+	// Directory
+	dir, err := rd.readChain(firstDirSector, rd.fat, rd.sectorSize, rd.sector, math.MaxUint64)
+	if err != nil {
+		return fmt.Errorf("directory: %w", err)
+	}
+	for i := 0; i+dirEntrySize <= len(dir); i += dirEntrySize {
+		rd.entries = append(rd.entries, rd.parseDirEntry(dir[i:i+dirEntrySize]))
+	}
+	if len(rd.entries) == 0 || rd.entries[0].objectType != typeRoot {
+		return fmt.Errorf("%w: root entry missing", ErrCorrupt)
+	}
+
+	// Mini FAT and mini stream (stored in the chain of the root entry)
+	if firstMiniFatSector != endOfChain {
+		miniFat, err := rd.readChain(firstMiniFatSector, rd.fat, rd.sectorSize, rd.sector, math.MaxUint64)
+		if err != nil {
+			return fmt.Errorf("mini FAT: %w", err)
+		}
+		for j := 0; j+4 <= len(miniFat); j += 4 {
+			rd.miniFat = append(rd.miniFat, binary.LittleEndian.Uint32(miniFat[j:]))
+		}
+	}
+	root := rd.entries[0]
+	if root.start != endOfChain && root.size > 0 {
+		rd.miniStream, err = rd.readStream(root.start, root.size, false)
+		if err != nil {
+			return fmt.Errorf("mini stream: %w", err)
+		}
+	}
+	return nil
+}
+
+// Returns the content of a sector
+func (rd *reader) sector(n uint32) ([]byte, error) {
+	offset := (uint64(n) + 1) * uint64(rd.sectorSize)
+	if offset >= uint64(len(rd.data)) {
+		return nil, fmt.Errorf("%w: sector %d beyond end of file", ErrCorrupt, n)
+	}
+	end := offset + uint64(rd.sectorSize)
+	if end > uint64(len(rd.data)) {
+		// The last sector may be truncated
+		b := make([]byte, rd.sectorSize)
+		copy(b, rd.data[offset:])
+		return b, nil
+	}
+	return rd.data[offset:end], nil
+}
+
+// Returns the content of a mini sector
+func (rd *reader) miniSector(n uint32) ([]byte, error) {
+	offset := uint64(n) * miniSectorSize
+	if offset+miniSectorSize > uint64(len(rd.miniStream)) {
+		return nil, fmt.Errorf("%w: mini sector %d beyond end of mini stream", ErrCorrupt, n)
+	}
+	return rd.miniStream[offset : offset+miniSectorSize], nil
+}
+
+// Reads a chain of sectors following the allocation table, until the end of the chain or until limit bytes are read
+func (rd *reader) readChain(start uint32, table []uint32, sectorSize int, sector func(uint32) ([]byte, error), limit uint64) ([]byte, error) {
+	// A chain cannot contain more sectors than present, otherwise it contains a cycle
+	maxSectors := min(len(table), len(rd.data)/sectorSize+1)
+	var res []byte
+	for n, count := start, 0; n != endOfChain && uint64(len(res)) < limit; count++ {
+		if int(n) >= len(table) || count >= maxSectors {
+			return nil, fmt.Errorf("%w: invalid sector chain", ErrCorrupt)
+		}
+		b, err := sector(n)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, b...)
+		n = table[n]
+	}
+	return res, nil
+}
+
+// Reads a stream from the FAT or the mini FAT
+func (rd *reader) readStream(start uint32, size uint64, mini bool) ([]byte, error) {
+	var b []byte
+	var err error
+	if mini {
+		b, err = rd.readChain(start, rd.miniFat, miniSectorSize, rd.miniSector, size)
+	} else {
+		b, err = rd.readChain(start, rd.fat, rd.sectorSize, rd.sector, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < size {
+		return nil, fmt.Errorf("%w: stream truncated (%d of %d bytes)", ErrCorrupt, len(b), size)
+	}
+	return b[:size], nil
+}
+
+func (rd *reader) parseDirEntry(b []byte) dirEntry {
+	e := dirEntry{
+		objectType: b[66],
+		left:       binary.LittleEndian.Uint32(b[68:]),
+		right:      binary.LittleEndian.Uint32(b[72:]),
+		child:      binary.LittleEndian.Uint32(b[76:]),
+		stateBits:  binary.LittleEndian.Uint32(b[96:]),
+		created:    binary.LittleEndian.Uint64(b[100:]),
+		modified:   binary.LittleEndian.Uint64(b[108:]),
+		start:      binary.LittleEndian.Uint32(b[116:]),
+		size:       binary.LittleEndian.Uint64(b[120:]),
+	}
+	copy(e.clsid[:], b[80:96])
+	// Version 3 files may contain garbage in the upper 32 bits of the size
+	if rd.majorVer == 3 {
+		e.size &= 0xFFFFFFFF
+	}
+	nameLength := int(binary.LittleEndian.Uint16(b[64:]))
+	if nameLength >= 2 && nameLength <= 64 {
+		name := make([]uint16, nameLength/2-1)
+		for i := range name {
+			name[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+		e.name = string(utf16.Decode(name))
+	}
+	return e
+}
+
+// Builds the tree of storages and streams starting at the root entry
+func (rd *reader) readTree() (*Storage, error) {
+	rd.visitedDirs = make([]bool, len(rd.entries))
+	rd.visitedDirs[0] = true
+	root := rd.newStorage(rd.entries[0])
+	if err := rd.readChildren(root, rd.entries[0].child); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Adds the entry with the given ID and its siblings to the storage
+func (rd *reader) readChildren(parent *Storage, id uint32) error {
+	if id == noStream {
+		return nil
+	}
+	if int(id) >= len(rd.entries) || rd.visitedDirs[id] {
+		return fmt.Errorf("%w: invalid directory entry %d", ErrCorrupt, id)
+	}
+	rd.visitedDirs[id] = true
+	e := rd.entries[id]
+	if err := rd.readChildren(parent, e.left); err != nil {
+		return err
+	}
+	switch e.objectType {
+	case typeStorage:
+		storage := rd.newStorage(e)
+		if err := rd.readChildren(storage, e.child); err != nil {
+			return err
+		}
+		parent.Storages = append(parent.Storages, storage)
+	case typeStream:
+		data, err := rd.readStream(e.start, e.size, e.size < uint64(rd.miniCutoff))
+		if err != nil {
+			return fmt.Errorf("stream %s: %w", e.name, err)
+		}
+		parent.Streams = append(parent.Streams, &Stream{Name: e.name, Data: data})
+	default:
+		return fmt.Errorf("%w: directory entry %d has type %d", ErrCorrupt, id, e.objectType)
+	}
+	return rd.readChildren(parent, e.right)
+}
+
+func (rd *reader) newStorage(e dirEntry) *Storage {
+	return &Storage{Name: e.name, CLSID: e.clsid, StateBits: e.stateBits, Created: e.created, Modified: e.modified}
+}
@@ -0,0 +1,276 @@
+package cfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+const sectorSize = 512 // files are written in version 3
+
+type writeEntry struct {
+	id         uint32
+	name       string
+	objectType byte
+	storage    *Storage
+	stream     *Stream
+	left       uint32
+	right      uint32
+	child      uint32
+	start      uint32
+	size       uint64
+}
+
+// Writes the storage as compound file (version 3, 512 byte sectors), the storage becomes the root entry.
+// Streams smaller than 4096 bytes are stored in the mini stream. The directory is written as a balanced tree of black nodes.
+func (s *Storage) WriteTo(w io.Writer) (int64, error) {
+	root := &writeEntry{name: "Root Entry", objectType: typeRoot, storage: s, left: noStream, right: noStream}
+	entries := []*writeEntry{root}
+	if err := addChildren(&entries, root); err != nil {
+		return 0, err
+	}
+
+	// Small streams go to the mini stream
+	var miniFat []uint32
+	var miniStream []byte
+	var largeStreams []*writeEntry
+	for _, e := range entries {
+		switch {
+		case e.objectType != typeStream:
+		case e.size == 0:
+			e.start = endOfChain
+		case e.size < miniCutoff:
+			e.start = uint32(len(miniFat))
+			n := sectors(e.size, miniSectorSize)
+			for i := uint32(1); i < n; i++ {
+				miniFat = append(miniFat, e.start+i)
+			}
+			miniFat = append(miniFat, endOfChain)
+			miniStream = append(miniStream, e.stream.Data...)
+			miniStream = append(miniStream, make([]byte, int(n)*miniSectorSize-len(e.stream.Data))...)
+		default:
+			largeStreams = append(largeStreams, e)
+		}
+	}
+
+	// Number of sectors of the FAT and DIFAT, which have to cover themselves as well
+	dirSectors := sectors(uint64(len(entries))*dirEntrySize, sectorSize)
+	miniFatSectors := sectors(uint64(len(miniFat))*4, sectorSize)
+	miniStreamSectors := sectors(uint64(len(miniStream)), sectorSize)
+	dataSectors := dirSectors + miniFatSectors + miniStreamSectors
+	for _, e := range largeStreams {
+		dataSectors += sectors(e.size, sectorSize)
+	}
+	var fatSectors, difatSectors uint32
+	for {
+		f := sectors(uint64(dataSectors+fatSectors+difatSectors)*4, sectorSize)
+		d := uint32(0)
+		if f > headerDifats {
+			d = sectors(uint64(f-headerDifats)*4, sectorSize-4)
+		}
+		if f == fatSectors && d == difatSectors {
+			break
+		}
+		fatSectors, difatSectors = f, d
+	}
+
+	// Allocate sectors: FAT, DIFAT, directory, mini FAT, mini stream, large streams
+	fat := make([]uint32, fatSectors*sectorSize/4)
+	for i := range fat {
+		fat[i] = freeSect
+	}
+	var next uint32
+	allocate := func(n uint32, mark uint32) uint32 {
+		if n == 0 {
+			return endOfChain
+		}
+		start := next
+		for i := uint32(0); i < n; i++ {
+			if mark != 0 {
+				fat[next] = mark
+			} else if i == n-1 {
+				fat[next] = endOfChain
+			} else {
+				fat[next] = next + 1
+			}
+			next++
+		}
+		return start
+	}
+	firstFatSector := allocate(fatSectors, fatSect)
+	firstDifatSector := allocate(difatSectors, difSect)
+	firstDirSector := allocate(dirSectors, 0)
+	firstMiniFatSector := allocate(miniFatSectors, 0)
+	root.start = allocate(miniStreamSectors, 0)
+	root.size = uint64(len(miniStream))
+	for _, e := range largeStreams {
+		e.start = allocate(sectors(e.size, sectorSize), 0)
+	}
+
+	// Header
+	var buf bytes.Buffer
+	header := make([]byte, headerSize)
+	copy(header, signature)
+	binary.LittleEndian.PutUint16(header[24:], 0x003E)
+	binary.LittleEndian.PutUint16(header[26:], 3)
+	binary.LittleEndian.PutUint16(header[28:], 0xFFFE)
+	binary.LittleEndian.PutUint16(header[30:], 9)
+	binary.LittleEndian.PutUint16(header[32:], 6)
+	binary.LittleEndian.PutUint32(header[44:], fatSectors)
+	binary.LittleEndian.PutUint32(header[48:], firstDirSector)
+	binary.LittleEndian.PutUint32(header[56:], miniCutoff)
+	binary.LittleEndian.PutUint32(header[60:], firstMiniFatSector)
+	binary.LittleEndian.PutUint32(header[64:], miniFatSectors)
+	binary.LittleEndian.PutUint32(header[68:], firstDifatSector)
+	binary.LittleEndian.PutUint32(header[72:], difatSectors)
+	for i := uint32(0); i < headerDifats; i++ {
+		sector := freeSect
+		if i < fatSectors {
+			sector = firstFatSector + i
+		}
+		binary.LittleEndian.PutUint32(header[76+4*i:], sector)
+	}
+	buf.Write(header)
+
+	// FAT and DIFAT
+	writeUint32s(&buf, fat)
+	for i := uint32(0); i < difatSectors; i++ {
+		difat := make([]uint32, sectorSize/4)
+		for j := range difat {
+			difat[j] = freeSect
+		}
+		for j := uint32(0); j < sectorSize/4-1; j++ {
+			if n := headerDifats + i*(sectorSize/4-1) + j; n < fatSectors {
+				difat[j] = firstFatSector + n
+			}
+		}
+		difat[sectorSize/4-1] = endOfChain
+		if i < difatSectors-1 {
+			difat[sectorSize/4-1] = firstDifatSector + i + 1
+		}
+		writeUint32s(&buf, difat)
+	}
+
+	// Directory
+	for _, e := range entries {
+		buf.Write(e.marshal())
+	}
+	for i := len(entries); i < int(dirSectors)*sectorSize/dirEntrySize; i++ {
+		unused := make([]byte, dirEntrySize)
+		binary.LittleEndian.PutUint32(unused[68:], noStream)
+		binary.LittleEndian.PutUint32(unused[72:], noStream)
+		binary.LittleEndian.PutUint32(unused[76:], noStream)
+		buf.Write(unused)
+	}
+
+	// Mini FAT, mini stream and large streams
+	for len(miniFat)%(sectorSize/4) != 0 {
+		miniFat = append(miniFat, freeSect)
+	}
+	writeUint32s(&buf, miniFat)
+	writePadded(&buf, miniStream)
+	for _, e := range largeStreams {
+		writePadded(&buf, e.stream.Data)
+	}
+	return buf.WriteTo(w)
+}
+
+// Adds the children of a storage to the entries and links them as balanced tree
+func addChildren(entries *[]*writeEntry, parent *writeEntry) error {
+	var children []*writeEntry
+	for _, storage := range parent.storage.Storages {
+		children = append(children, &writeEntry{name: storage.Name, objectType: typeStorage, storage: storage})
+	}
+	for _, stream := range parent.storage.Streams {
+		if uint64(len(stream.Data)) > 0x7FFFFFFF {
+			return fmt.Errorf("cfb: stream %s too large", stream.Name)
+		}
+		children = append(children, &writeEntry{name: stream.Name, objectType: typeStream, stream: stream, size: uint64(len(stream.Data))})
+	}
+	slices.SortFunc(children, func(a, b *writeEntry) int { return compareNames(a.name, b.name) })
+	for i, child := range children {
+		if err := validateName(child.name); err != nil {
+			return err
+		}
+		if i > 0 && compareNames(children[i-1].name, child.name) == 0 {
+			return fmt.Errorf("cfb: duplicate name %s in storage %s", child.name, parent.name)
+		}
+		child.id = uint32(len(*entries))
+		*entries = append(*entries, child)
+	}
+	parent.child = linkTree(children)
+	for _, child := range children {
+		if child.objectType == typeStorage {
+			if err := addChildren(entries, child); err != nil {
+				return err
+			}
+		} else {
+			child.child = noStream
+		}
+	}
+	return nil
+}
+
+// Links sorted entries as balanced binary tree, returns the ID of the root node
+func linkTree(sorted []*writeEntry) uint32 {
+	if len(sorted) == 0 {
+		return noStream
+	}
+	mid := len(sorted) / 2
+	sorted[mid].left = linkTree(sorted[:mid])
+	sorted[mid].right = linkTree(sorted[mid+1:])
+	return sorted[mid].id
+}
+
+func validateName(name string) error {
+	if name == "" || len(utf16.Encode([]rune(name))) > maxNameLength || strings.ContainsAny(name, "/\\:!") {
+		return fmt.Errorf("cfb: invalid name %q", name)
+	}
+	return nil
+}
+
+func (e *writeEntry) marshal() []byte {
+	b := make([]byte, dirEntrySize)
+	name := utf16.Encode([]rune(e.name))
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	binary.LittleEndian.PutUint16(b[64:], uint16(2*(len(name)+1)))
+	b[66] = e.objectType
+	b[67] = 1 // black
+	binary.LittleEndian.PutUint32(b[68:], e.left)
+	binary.LittleEndian.PutUint32(b[72:], e.right)
+	binary.LittleEndian.PutUint32(b[76:], e.child)
+	if e.storage != nil {
+		copy(b[80:96], e.storage.CLSID[:])
+		binary.LittleEndian.PutUint32(b[96:], e.storage.StateBits)
+		binary.LittleEndian.PutUint64(b[100:], e.storage.Created)
+		binary.LittleEndian.PutUint64(b[108:], e.storage.Modified)
+	}
+	binary.LittleEndian.PutUint32(b[116:], e.start)
+	binary.LittleEndian.PutUint64(b[120:], e.size)
+	return b
+}
+
+// Number of sectors of the given size required for n bytes
+func sectors(n uint64, size uint64) uint32 {
+	return uint32((n + size - 1) / size)
+}
+
+func writeUint32s(buf *bytes.Buffer, values []uint32) {
+	for _, v := range values {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+// Writes data padded with zeros to a multiple of the sector size
+func writePadded(buf *bytes.Buffer, data []byte) {
+	buf.Write(data)
+	if rem := len(data) % sectorSize; rem != 0 {
+		buf.Write(make([]byte, sectorSize-rem))
+	}
+}
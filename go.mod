@@ -4,6 +4,13 @@ go 1.24.0
 
 require golang.org/x/text v0.25.0
 
-require github.com/richardlehane/msoleps v1.0.4 // indirect
+require (
+	github.com/richardlehane/msoleps v1.0.4 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
+)
 
-require github.com/richardlehane/mscfb v1.0.4
+require (
+	github.com/miekg/pkcs11 v1.1.2
+	github.com/richardlehane/mscfb v1.0.4
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
@@ -0,0 +1,248 @@
+// Package tsatest provides a time stamping authority (TSA) stand-in for tests
+package tsatest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/pkcs7"
+)
+
+// TSA answers RFC 3161 timestamp requests and legacy Authenticode countersignature requests (base64 encoded,
+// told apart by the content type), signed with a throwaway time stamping certificate
+type TSA struct {
+	URL         string
+	Certificate *x509.Certificate
+
+	cfg    Config
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	serial int64
+}
+
+// Config of a TSA, the zero value answers all requests correctly
+type Config struct {
+	Time         time.Time // time of the timestamps, default now
+	Status       int       // PKIStatus of the responses, 0 (granted) by default
+	WrongNonce   bool      // answer with a different nonce than requested
+	WrongImprint bool      // answer with a different message imprint (countersign different content) than requested
+}
+
+// The key and certificate are shared by all TSAs of a test binary, RSA key generation is slow
+var (
+	identityOnce sync.Once
+	identityKey  *rsa.PrivateKey
+	identityCert *x509.Certificate
+	identityErr  error
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status int
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type authenticodeTimestampReq struct {
+	CountersignatureType asn1.ObjectIdentifier
+	Content              contentInfo
+}
+
+// New starts a TSA that is stopped at the end of the test
+func New(t testing.TB, cfg Config) *TSA {
+	t.Helper()
+	identityOnce.Do(func() {
+		identityKey, identityCert, identityErr = newIdentity()
+	})
+	if identityErr != nil {
+		t.Fatal(identityErr)
+	}
+	tsa := &TSA{Certificate: identityCert, cfg: cfg, key: identityKey}
+	server := httptest.NewServer(http.HandlerFunc(tsa.serveHTTP))
+	t.Cleanup(server.Close)
+	tsa.URL = server.URL
+	return tsa
+}
+
+// Self-signed time stamping certificate
+func newIdentity() (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vbasig test TSA"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+// Roots returns a pool with the certificate of the TSA
+func (tsa *TSA) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(tsa.Certificate)
+	return pool
+}
+
+func (tsa *TSA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tsa.mu.Lock()
+	defer tsa.mu.Unlock()
+	if r.Header.Get("Content-Type") != "application/timestamp-query" {
+		resp, err := tsa.countersign(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(resp)))
+		return
+	}
+	resp, err := tsa.respond(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp)
+}
+
+// Answers a legacy Authenticode request with signed data over the requested content,
+// its signer carries the time as signing time
+func (tsa *TSA) countersign(body []byte) ([]byte, error) {
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, err
+	}
+	var req authenticodeTimestampReq
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		return nil, err
+	}
+	var data []byte
+	if _, err := asn1.Unmarshal(req.Content.Content.Bytes, &data); err != nil {
+		return nil, err
+	}
+	if tsa.cfg.WrongImprint {
+		data = append([]byte("other "), data...)
+	}
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(tsa.Certificate, tsa.key, pkcs7.SignerInfoConfig{
+		ExtraSignedAttributes: []pkcs7.Attribute{{Type: pkcs7.OIDAttributeSigningTime, Value: tsa.now()}},
+	}); err != nil {
+		return nil, err
+	}
+	return sd.Finish()
+}
+
+// Answers a RFC 3161 request
+func (tsa *TSA) respond(body []byte) ([]byte, error) {
+	var req timeStampReq
+	if _, err := asn1.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if tsa.cfg.Status > 1 {
+		return asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: tsa.cfg.Status}})
+	}
+	if tsa.cfg.WrongNonce {
+		req.Nonce = new(big.Int).Add(req.Nonce, big.NewInt(1))
+	}
+	if tsa.cfg.WrongImprint {
+		req.MessageImprint.HashedMessage = append([]byte{}, req.MessageImprint.HashedMessage...)
+		req.MessageImprint.HashedMessage[0] ^= 0xff
+	}
+	tsa.serial++
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   big.NewInt(tsa.serial),
+		GenTime:        tsa.now(),
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sd, err := pkcs7.NewSignedData(info)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	sd.GetSignedData().ContentInfo.ContentType = pkcs7.OIDTSTInfo
+	if err := sd.AddSigner(tsa.Certificate, tsa.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	token, err := sd.Finish()
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: tsa.cfg.Status}, TimeStampToken: asn1.RawValue{FullBytes: token}})
+}
+
+func (tsa *TSA) now() time.Time {
+	if tsa.cfg.Time.IsZero() {
+		return time.Now().UTC().Truncate(time.Second)
+	}
+	return tsa.cfg.Time
+}
@@ -1,24 +1,394 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/coffeeforyou/vbasig/pkcs11signer"
+	"github.com/coffeeforyou/vbasig/util"
 	"github.com/coffeeforyou/vbasig/vbaproject"
 )
 
 func main() {
-	officeFilePath := flag.String("f", "", "file to sign (.xlsm, .docm, .pptm)")
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verify(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "timestamp" {
+		timestamp(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "unsign" {
+		unsign(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		doctor(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prepare" {
+		prepare(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "attach" {
+		attach(os.Args[2:])
+		return
+	}
+	officeFilePath := flag.String("f", "", "file to sign (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ..., .xls, .doc, vbaProject.bin)")
 	certPath := flag.String("c", "", "certificate for signing (.crt)")
 	keyPath := flag.String("s", "", "private key for signing (.key)")
 	caPath := flag.String("i", "", "(optional) issuing certificate (.pem)")
+	writeManifest := flag.Bool("m", false, "(optional) write a manifest of the VBA project next to the signed file")
+	timestampURL := flag.String("t", "", "(optional) URL of a RFC 3161 time stamping authority")
+	timestampLegacy := flag.Bool("t-legacy", false, "(optional) request a legacy Authenticode timestamp from -t instead of RFC 3161")
+	keepSignatures := flag.Bool("keep", false, "(optional) keep existing signatures of other versions (e.g. V1), they must still be valid")
+	skipIfSigned := flag.Bool("skip-signed", false, "(optional) do not write the signed file if the file already has valid signatures by the same certificate")
+	deterministic := flag.Bool("deterministic", false, "(optional) write the same output for the same input, key and options (RSA keys only, no timestamps)")
+	signingTime := flag.String("signing-time", "", "(optional) signing time recorded in the signatures and modification time of the parts written (RFC 3339), parts get 1980-01-01 with -deterministic if not set")
+	vbaFolder := flag.String("vba-folder", "", "(optional) folder of a standalone vbaProject.bin in the package, for the part names in the content types (default xl)")
+	var p11 pkcs11Flags
+	flag.StringVar(&p11.module, "pkcs11", "", "(optional) PKCS#11 module holding the signing key, instead of -s")
+	flag.StringVar(&p11.token, "token", "", "(optional) label of the PKCS#11 token, default first token")
+	flag.StringVar(&p11.keyLabel, "key-label", "", "(optional) label of the private key in the PKCS#11 token")
+	flag.StringVar(&p11.keyID, "key-id", "", "(optional) ID (hex) of the private key in the PKCS#11 token")
+	flag.StringVar(&p11.pinEnv, "pin-env", "", "(optional) environment variable containing the PKCS#11 PIN")
+	flag.StringVar(&p11.pinFile, "pin-file", "", "(optional) file containing the PKCS#11 PIN")
+	var p12 pkcs12Flags
+	flag.StringVar(&p12.path, "p12", "", "(optional) PKCS#12 file (.pfx, .p12) with certificate, private key and chain, instead of -c, -s and -i")
+	flag.StringVar(&p12.password, "p12-pass", "", "(optional) password of the PKCS#12 file")
+	flag.StringVar(&p12.passwordEnv, "p12-pass-env", "", "(optional) environment variable containing the password of the PKCS#12 file")
+	flag.IntVar(&p12.passwordFd, "p12-pass-fd", -1, "(optional) file descriptor to read the password of the PKCS#12 file from")
 	flag.Parse()
-	if *officeFilePath == "" || *certPath == "" || *keyPath == "" {
+	if *officeFilePath == "" || (p11.module == "" && p12.path == "" && (*certPath == "" || *keyPath == "")) {
 		flag.Usage()
 		return
 	}
+	for _, err := range []error{p12.validate(), p11.validate()} {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	var signingTimeValue time.Time
+	if *signingTime != "" {
+		var err error
+		signingTimeValue, err = time.Parse(time.RFC3339, *signingTime)
+		util.TerminateIfErr(err)
+	}
 	so := vbaproject.SignOptions{
-		IncludeV1:    false,
-		IncludeAgile: false,
-		IncludeV3:    true}
-	vbaproject.SignVbaProject(*officeFilePath, *certPath, *keyPath, *caPath, so)
+		IncludeV1:       false,
+		IncludeAgile:    false,
+		IncludeV3:       true,
+		WriteManifest:   *writeManifest,
+		TimestampURL:    *timestampURL,
+		TimestampLegacy: *timestampLegacy,
+		VbaFolder:       *vbaFolder,
+		KeepSignatures:  *keepSignatures,
+		SkipIfSigned:    *skipIfSigned,
+		Deterministic:   *deterministic,
+		SigningTime:     signingTimeValue}
+	var id *vbaproject.Identity
+	var signer *pkcs11signer.Signer
+	var err error
+	switch {
+	case p11.module != "":
+		signer, id, err = p11.identity(*certPath, *caPath)
+	case p12.path != "":
+		id, err = p12.identity()
+	default:
+		id, err = vbaproject.LoadIdentity(*certPath, *keyPath, *caPath)
+	}
+	util.TerminateIfErr(err)
+	report, err := vbaproject.SignVbaProjectWithReport(*officeFilePath, id, so)
+	if signer != nil {
+		signer.Close()
+	}
+	util.TerminateIfErr(err)
+	fmt.Println(report)
+}
+
+type pkcs12Flags struct {
+	path, password, passwordEnv string
+	passwordFd                  int
+}
+
+// Checks that at most one source of the password is given
+func (f pkcs12Flags) validate() error {
+	n := 0
+	for _, set := range []bool{f.password != "", f.passwordEnv != "", f.passwordFd >= 0} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("-p12-pass, -p12-pass-env and -p12-pass-fd are mutually exclusive")
+	}
+	return nil
+}
+
+// Loads the PKCS#12 file with the password from the flag, the environment or a file descriptor
+func (f pkcs12Flags) identity() (*vbaproject.Identity, error) {
+	password := f.password
+	switch {
+	case f.passwordEnv != "":
+		var ok bool
+		if password, ok = os.LookupEnv(f.passwordEnv); !ok {
+			return nil, fmt.Errorf("environment variable %s not set", f.passwordEnv)
+		}
+	case f.passwordFd >= 0:
+		b, err := io.ReadAll(os.NewFile(uintptr(f.passwordFd), "p12-pass-fd"))
+		if err != nil {
+			return nil, fmt.Errorf("reading password failed: %w", err)
+		}
+		password, _, _ = strings.Cut(string(b), "\n")
+		password = strings.TrimRight(password, "\r")
+	}
+	return vbaproject.LoadIdentityPKCS12(f.path, password)
+}
+
+type pkcs11Flags struct {
+	module, token, keyLabel, keyID, pinEnv, pinFile string
+}
+
+// Checks that at most one source of the PIN is given
+func (f pkcs11Flags) validate() error {
+	if f.pinEnv != "" && f.pinFile != "" {
+		return errors.New("-pin-env and -pin-file are mutually exclusive")
+	}
+	return nil
+}
+
+// Opens the PKCS#11 signer, the certificate is read from the token unless given as file
+func (f pkcs11Flags) identity(certPath string, caPath string) (*pkcs11signer.Signer, *vbaproject.Identity, error) {
+	cfg := pkcs11signer.Config{Module: f.module, TokenLabel: f.token, KeyLabel: f.keyLabel}
+	var err error
+	if cfg.KeyID, err = hex.DecodeString(f.keyID); err != nil {
+		return nil, nil, fmt.Errorf("invalid key ID: %w", err)
+	}
+	if cfg.PIN, err = pkcs11signer.ReadPIN(f.pinEnv, f.pinFile); err != nil {
+		return nil, nil, err
+	}
+	signer, err := pkcs11signer.New(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, err := signingChain(signer, certPath, caPath)
+	if err != nil {
+		signer.Close()
+		return nil, nil, err
+	}
+	id, err := vbaproject.NewIdentity(signer, chain)
+	if err != nil {
+		signer.Close()
+		return nil, nil, err
+	}
+	return signer, id, nil
+}
+
+func signingChain(signer *pkcs11signer.Signer, certPath string, caPath string) ([]*x509.Certificate, error) {
+	var cert *x509.Certificate
+	var err error
+	if certPath != "" {
+		cert, err = util.LoadPemCertificate(certPath)
+	} else {
+		cert, err = signer.Certificate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	chain := []*x509.Certificate{cert}
+	if caPath != "" {
+		caCert, err := util.LoadPemCertificate(caPath)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, caCert)
+	}
+	return chain, nil
+}
+
+// Verifies the VBA signatures of a file, exits with 1 if a signature is invalid or missing
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "file to verify (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ..., .xls, .doc, .ppt)")
+	manifestPath := fs.String("m", "", "(optional) manifest written at signing time, to list the changed parts of the VBA project")
+	rootsPath := fs.String("ca", "", "(optional) trusted root certificates (.pem) to verify the certificate chains of signers and time stamping authorities")
+	fs.Parse(args)
+	if *officeFilePath == "" {
+		fs.Usage()
+		return
+	}
+	var vo vbaproject.VerifyOptions
+	if *rootsPath != "" {
+		pemBytes, err := os.ReadFile(*rootsPath)
+		util.TerminateIfErr(err)
+		vo.Roots = x509.NewCertPool()
+		if !vo.Roots.AppendCertsFromPEM(pemBytes) {
+			util.TerminateIfErr(fmt.Errorf("no certificates found in %s", *rootsPath))
+		}
+	}
+	results, err := vbaproject.VerifyVbaProjectWithOptions(*officeFilePath, vo)
+	util.TerminateIfErr(err)
+	if len(results) == 0 {
+		util.TerminateIfErr(fmt.Errorf("no VBA signature found in %s", *officeFilePath))
+	}
+	valid := true
+	for _, r := range results {
+		fmt.Println(r)
+		valid = valid && r.Valid
+	}
+	if *manifestPath != "" {
+		changes, err := vbaproject.CompareWithManifest(*officeFilePath, *manifestPath)
+		util.TerminateIfErr(err)
+		if len(changes) == 0 {
+			fmt.Println("No changes of the VBA project since signing")
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+// Adds timestamps to the VBA signatures of a signed file, without the private key
+func timestamp(args []string) {
+	fs := flag.NewFlagSet("timestamp", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "signed file to timestamp (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ..., .xls, .doc)")
+	timestampURL := fs.String("t", "", "URL of a RFC 3161 time stamping authority")
+	timestampLegacy := fs.Bool("t-legacy", false, "(optional) request a legacy Authenticode timestamp from -t instead of RFC 3161")
+	fs.Parse(args)
+	if *officeFilePath == "" || *timestampURL == "" {
+		fs.Usage()
+		return
+	}
+	err := vbaproject.TimestampVbaProject(*officeFilePath, vbaproject.SignOptions{TimestampURL: *timestampURL, TimestampLegacy: *timestampLegacy})
+	util.TerminateIfErr(err)
+}
+
+// Removes the VBA signatures of a file, e.g. before editing it or signing it with another certificate
+func unsign(args []string) {
+	fs := flag.NewFlagSet("unsign", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "signed file to unsign (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ..., .xls, .doc)")
+	fs.Parse(args)
+	if *officeFilePath == "" {
+		fs.Usage()
+		return
+	}
+	util.TerminateIfErr(vbaproject.UnsignVbaProject(*officeFilePath))
+}
+
+// Checks the package structure around the VBA project of a file, exits with 1 if a problem is left
+func doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "file to check (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ...)")
+	fix := fs.Bool("fix", false, "(optional) fix the problems found and write the repaired file as <file>-repaired.<ext>")
+	fs.Parse(args)
+	if *officeFilePath == "" {
+		fs.Usage()
+		return
+	}
+	var problems []vbaproject.Problem
+	var err error
+	if *fix {
+		problems, err = vbaproject.RepairVbaProject(*officeFilePath)
+	} else {
+		problems, err = vbaproject.CheckVbaProject(*officeFilePath)
+	}
+	util.TerminateIfErr(err)
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+	}
+	ok := true
+	for _, p := range problems {
+		fmt.Println(p)
+		ok = ok && p.Fixed
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Writes a signing request and the signed attributes to be signed elsewhere (<file>.<version>.tbs), without the private key
+func prepare(args []string) {
+	fs := flag.NewFlagSet("prepare", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "file to sign (.xlsm, .xlsb, .xlam, .docm, .dotm, .pptm, .ppam, .vsdm, ..., .xls, .doc)")
+	certPath := fs.String("c", "", "certificate for signing (.crt)")
+	caPath := fs.String("i", "", "(optional) issuing certificate (.pem)")
+	requestPath := fs.String("r", "", "(optional) signing request to write, default <file>.sigreq.json")
+	fs.Parse(args)
+	if *officeFilePath == "" || *certPath == "" {
+		fs.Usage()
+		return
+	}
+	cert, err := util.LoadPemCertificate(*certPath)
+	util.TerminateIfErr(err)
+	chain := []*x509.Certificate{cert}
+	if *caPath != "" {
+		caCert, err := util.LoadPemCertificate(*caPath)
+		util.TerminateIfErr(err)
+		chain = append(chain, caCert)
+	}
+	officeFile, err := os.Open(*officeFilePath)
+	util.TerminateIfErr(err)
+	defer officeFile.Close()
+	fi, err := officeFile.Stat()
+	util.TerminateIfErr(err)
+	req, err := vbaproject.PrepareSigning(officeFile, fi.Size(), chain, vbaproject.SignOptions{IncludeV3: true})
+	util.TerminateIfErr(err)
+	if *requestPath == "" {
+		*requestPath = vbaproject.SigningRequestPath(*officeFilePath)
+	}
+	util.TerminateIfErr(req.Save(*requestPath))
+	for _, sr := range req.Signatures {
+		tbsPath := fmt.Sprintf("%s.%s.tbs", *officeFilePath, sr.Signature)
+		util.TerminateIfErr(os.WriteFile(tbsPath, sr.SignedAttributes, 0644))
+		util.TerminateIfErr(os.WriteFile(fmt.Sprintf("%s.%s.content", *officeFilePath, sr.Signature), sr.SignedContent, 0644))
+		fmt.Printf("%s: sign %s with %s, e.g. openssl dgst -%s -sign key.pem -out %s.%s.sig %s\n",
+			sr.Signature, tbsPath, sr.DigestAlgorithm, strings.ToLower(strings.ReplaceAll(sr.DigestAlgorithm, "-", "")), *officeFilePath, sr.Signature, tbsPath)
+	}
+}
+
+// Attaches the signatures created for a signing request and writes the signed file
+func attach(args []string) {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	officeFilePath := fs.String("f", "", "file the signing request was prepared for")
+	requestPath := fs.String("r", "", "(optional) signing request, default <file>.sigreq.json")
+	signaturePaths := map[vbaproject.SignatureVersion]*string{}
+	for _, version := range vbaproject.SignatureVersions {
+		signaturePaths[version] = fs.String("sig-"+strings.ToLower(version.String()), "",
+			fmt.Sprintf("(optional) signature value or PKCS#7 signature (.p7s) for the %s signature, default <file>.%s.sig", version, version))
+	}
+	timestampURL := fs.String("t", "", "(optional) URL of a RFC 3161 time stamping authority")
+	timestampLegacy := fs.Bool("t-legacy", false, "(optional) request a legacy Authenticode timestamp from -t instead of RFC 3161")
+	fs.Parse(args)
+	if *officeFilePath == "" {
+		fs.Usage()
+		return
+	}
+	if *requestPath == "" {
+		*requestPath = vbaproject.SigningRequestPath(*officeFilePath)
+	}
+	req, err := vbaproject.LoadSigningRequest(*requestPath)
+	util.TerminateIfErr(err)
+	signatures := map[vbaproject.SignatureVersion][]byte{}
+	for _, sr := range req.Signatures {
+		path := *signaturePaths[sr.Signature]
+		if path == "" {
+			path = fmt.Sprintf("%s.%s.sig", *officeFilePath, sr.Signature)
+		}
+		signatures[sr.Signature], err = os.ReadFile(path)
+		util.TerminateIfErr(err)
+	}
+	err = vbaproject.AttachVbaProjectSignatures(*officeFilePath, req, signatures, vbaproject.SignOptions{TimestampURL: *timestampURL, TimestampLegacy: *timestampLegacy})
+	util.TerminateIfErr(err)
 }
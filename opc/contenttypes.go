@@ -0,0 +1,69 @@
+package opc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+)
+
+type Types struct {
+	XMLName   xml.Name   `xml:"http://schemas.openxmlformats.org/package/2006/content-types Types"`
+	Defaults  []Default  `xml:"Default"`
+	Overrides []Override `xml:"Override"`
+}
+
+type Default struct {
+	Extension   string `xml:"Extension,attr"`
+	ContentType string `xml:"ContentType,attr"`
+}
+
+type Override struct {
+	PartName    string `xml:"PartName,attr"`
+	ContentType string `xml:"ContentType,attr"`
+}
+
+// Reads the content types of the package ([Content_Types].xml), fs.ErrNotExist if missing
+func (pkg *Package) ContentTypes() (*Types, error) {
+	data, err := pkg.ReadPart(ContentTypesName)
+	if err != nil {
+		return nil, err
+	}
+	var types Types
+	if err = xml.Unmarshal(data, &types); err != nil {
+		return nil, fmt.Errorf("%s: %w", ContentTypesName, err)
+	}
+	return &types, nil
+}
+
+// Content type of a part: its override, otherwise the default of its extension. Empty if neither is present.
+func (t *Types) ContentType(partName string) string {
+	partName = "/" + strings.TrimPrefix(partName, "/")
+	for _, o := range t.Overrides {
+		if strings.EqualFold(o.PartName, partName) {
+			return o.ContentType
+		}
+	}
+	ext := strings.TrimPrefix(path.Ext(partName), ".")
+	for _, d := range t.Defaults {
+		if strings.EqualFold(d.Extension, ext) {
+			return d.ContentType
+		}
+	}
+	return ""
+}
+
+// Parts with the given content type (compared case-insensitive), in the order of the package
+func (pkg *Package) PartsByContentType(contentType string) ([]*Part, error) {
+	types, err := pkg.ContentTypes()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Part
+	for _, p := range pkg.parts {
+		if strings.EqualFold(types.ContentType(p.Name), contentType) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+// Package opc reads and writes packages of the Open Packaging Conventions (ECMA-376 Part 2), the zip container of
+// Office Open XML files. Parts are looked up by name case-insensitively, relationships are resolved relative to their
+// source part and content types from the overrides and defaults of [Content_Types].xml.
+// When a package is written, parts that were not changed are copied raw (compressed data and headers unchanged).
+package opc
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+var ErrFormat = errors.New("opc: not a zip package")
+
+// Name of the content types part
+const ContentTypesName = "[Content_Types].xml"
+
+// Package read from a zip archive, parts can be replaced, added and removed before writing it again
+type Package struct {
+	zip   *zip.Reader
+	parts []*Part
+}
+
+// Part of a package
+type Part struct {
+	Name string    // name of the zip entry, i.e. the part name without the leading slash (e.g. xl/workbook.xml)
+	file *zip.File // entry in the original package, nil for new parts
+	data []byte    // new data, nil if unchanged
+}
+
+// Opens a package of the given size, archives that are no zip file are reported as ErrFormat
+func Open(r io.ReaderAt, size int64) (*Package, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if errors.Is(err, zip.ErrFormat) {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pkg := &Package{zip: zipReader}
+	for _, f := range zipReader.File {
+		pkg.parts = append(pkg.parts, &Part{Name: f.Name, file: f})
+	}
+	return pkg, nil
+}
+
+// Parts of the package in the order of the zip archive, new parts last
+func (pkg *Package) Parts() []*Part {
+	return pkg.parts
+}
+
+// Returns the part with the given name (with or without leading slash), nil if not present.
+// Part names are compared case-insensitive as required by OPC, an exact match is preferred.
+func (pkg *Package) Part(name string) *Part {
+	name = strings.TrimPrefix(name, "/")
+	var res *Part
+	for _, p := range pkg.parts {
+		if p.Name == name {
+			return p
+		}
+		if res == nil && strings.EqualFold(p.Name, name) {
+			res = p
+		}
+	}
+	return res
+}
+
+// Reads the data of a part, fs.ErrNotExist if not present
+func (pkg *Package) ReadPart(name string) ([]byte, error) {
+	p := pkg.Part(name)
+	if p == nil {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return p.Data()
+}
+
+// Data of the part
+func (p *Part) Data() ([]byte, error) {
+	if p.data != nil || p.file == nil {
+		return p.data, nil
+	}
+	f, err := p.file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Reports whether the part was changed or added
+func (p *Part) Changed() bool {
+	return p.data != nil
+}
+
+// Sets the data of a part, an existing part with the name (compared case-insensitive) is replaced, otherwise the part is added.
+// Returns the part.
+func (pkg *Package) SetPart(name string, data []byte) *Part {
+	if data == nil {
+		data = []byte{}
+	}
+	p := pkg.Part(name)
+	if p == nil {
+		p = &Part{Name: strings.TrimPrefix(name, "/")}
+		pkg.parts = append(pkg.parts, p)
+	}
+	p.data = data
+	return p
+}
+
+// Removes a part, reports whether it was present
+func (pkg *Package) RemovePart(name string) bool {
+	p := pkg.Part(name)
+	if p == nil {
+		return false
+	}
+	pkg.parts = slices.DeleteFunc(pkg.parts, func(q *Part) bool { return q == p })
+	return true
+}
@@ -0,0 +1,200 @@
+package opc
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+// Opens a package with the given parts (name and data pairs), in that order
+func openPackage(t *testing.T, parts ...[2]string) *Package {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, part := range parts {
+		w, err := zw.Create(part[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(part[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestOpenNotZip(t *testing.T) {
+	data := []byte("not a zip archive")
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrFormat) {
+		t.Errorf("got error %v, want ErrFormat", err)
+	}
+}
+
+func TestPart(t *testing.T) {
+	pkg := openPackage(t, [2]string{"xl/Workbook.xml", "upper"}, [2]string{"xl/workbook.xml", "lower"})
+	tests := []struct {
+		name string
+		want string // data of the part found, empty if none
+	}{
+		{"xl/workbook.xml", "lower"},
+		{"/xl/workbook.xml", "lower"},
+		{"xl/Workbook.xml", "upper"},
+		{"XL/WORKBOOK.XML", "upper"},
+		{"xl/missing.xml", ""},
+	}
+	for _, test := range tests {
+		p := pkg.Part(test.name)
+		var got string
+		if p != nil {
+			data, err := p.Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = string(data)
+		}
+		if got != test.want {
+			t.Errorf("Part(%q) has data %q, want %q", test.name, got, test.want)
+		}
+	}
+	if _, err := pkg.ReadPart("xl/missing.xml"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for a missing part, want fs.ErrNotExist", err)
+	}
+}
+
+func TestSetAndRemovePart(t *testing.T) {
+	pkg := openPackage(t, [2]string{"a.xml", "a"}, [2]string{"b.xml", "b"})
+	pkg.SetPart("/A.XML", []byte("changed"))
+	pkg.SetPart("c.xml", []byte("added"))
+	if !pkg.RemovePart("b.xml") || pkg.RemovePart("b.xml") {
+		t.Error("RemovePart does not report whether the part was present")
+	}
+	var names []string
+	for _, p := range pkg.Parts() {
+		names = append(names, p.Name)
+	}
+	if want := []string{"a.xml", "c.xml"}; !slices.Equal(names, want) {
+		t.Errorf("got parts %q, want %q", names, want)
+	}
+	if data, err := pkg.ReadPart("a.xml"); err != nil || string(data) != "changed" || !pkg.Part("a.xml").Changed() {
+		t.Errorf("got %q, %v for the replaced part", data, err)
+	}
+}
+
+func TestRelationshipsPartName(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"", "_rels/.rels"},
+		{"xl/workbook.xml", "xl/_rels/workbook.xml.rels"},
+		{"/xl/vbaProject.bin", "xl/_rels/vbaProject.bin.rels"},
+		{"docProps/app.xml", "docProps/_rels/app.xml.rels"},
+	}
+	for _, test := range tests {
+		if got := RelationshipsPartName(test.source); got != test.want {
+			t.Errorf("RelationshipsPartName(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	tests := []struct {
+		source, target, want string
+	}{
+		{"", "xl/workbook.xml", "xl/workbook.xml"},
+		{"xl/workbook.xml", "vbaProject.bin", "xl/vbaProject.bin"},
+		{"xl/workbook.xml", "/xl/vbaProject.bin", "xl/vbaProject.bin"},
+		{"xl/workbook.xml", "../customXml/item1.xml", "customXml/item1.xml"},
+		{"xl/worksheets/sheet1.xml", "../media/bild%C3%A4.png", "xl/media/bildä.png"},
+		{"/xl/vbaProject.bin", "./signatures/v3.bin", "xl/signatures/v3.bin"},
+	}
+	for _, test := range tests {
+		if got := ResolveTarget(test.source, test.target); got != test.want {
+			t.Errorf("ResolveTarget(%q, %q) = %q, want %q", test.source, test.target, got, test.want)
+		}
+	}
+}
+
+func TestRelatedPart(t *testing.T) {
+	const relType = "http://schemas.microsoft.com/office/2006/relationships/vbaProject"
+	pkg := openPackage(t,
+		[2]string{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + relType + `" Target="https://example.com/vbaProject.bin" TargetMode="External"/>` +
+			`<Relationship Id="rId2" Type="` + relType + `" Target="missing.bin"/>` +
+			`<Relationship Id="rId3" Type="` + relType + `" Target="/macros/vbaProject.bin"/>` +
+			`</Relationships>`},
+		[2]string{"xl/workbook.xml", "<workbook/>"},
+		[2]string{"macros/vbaProject.bin", "vba"},
+	)
+	relationships, err := pkg.Relationships("xl/workbook.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relationships.Relationships) != 3 || !relationships.Relationships[0].IsExternal() || relationships.Relationships[1].IsExternal() {
+		t.Errorf("got relationships %+v", relationships.Relationships)
+	}
+
+	// External targets and missing parts are skipped
+	if p := pkg.RelatedPart("xl/workbook.xml", relType); p == nil || p.Name != "macros/vbaProject.bin" {
+		t.Errorf("got related part %v, want macros/vbaProject.bin", p)
+	}
+	if p := pkg.RelatedPart("xl/workbook.xml", "urn:other"); p != nil {
+		t.Errorf("got related part %s for another type", p.Name)
+	}
+	if _, err := pkg.Relationships(""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for missing package relationships, want fs.ErrNotExist", err)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	pkg := openPackage(t,
+		[2]string{ContentTypesName, `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` +
+			`<Default Extension="XML" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/vbaProjectSignature.bin" ContentType="application/vnd.ms-office.vbaProjectSignature"/>` +
+			`<Override PartName="/XL/Workbook.xml" ContentType="application/vnd.ms-excel.sheet.macroEnabled.main+xml"/>` +
+			`</Types>`},
+		[2]string{"xl/workbook.xml", "<workbook/>"},
+		[2]string{"xl/vbaProject.bin", "vba"},
+		[2]string{"xl/vbaProjectSignature.bin", "signature"},
+		[2]string{"xl/media/image1.png", "\x89PNG"},
+	)
+	types, err := pkg.ContentTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		partName, want string
+	}{
+		{"xl/workbook.xml", "application/vnd.ms-excel.sheet.macroEnabled.main+xml"},
+		{"/xl/vbaProject.bin", "application/vnd.ms-office.vbaProject"},
+		{"xl/vbaProjectSignature.bin", "application/vnd.ms-office.vbaProjectSignature"},
+		{"docProps/app.xml", "application/xml"},
+		{"xl/media/image1.png", ""},
+	}
+	for _, test := range tests {
+		if got := types.ContentType(test.partName); got != test.want {
+			t.Errorf("ContentType(%q) = %q, want %q", test.partName, got, test.want)
+		}
+	}
+
+	parts, err := pkg.PartsByContentType("Application/vnd.ms-office.vbaProject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].Name != "xl/vbaProject.bin" {
+		t.Errorf("got parts %v, want xl/vbaProject.bin", parts)
+	}
+	if _, err := openPackage(t).ContentTypes(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for missing content types, want fs.ErrNotExist", err)
+	}
+}
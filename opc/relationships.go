@@ -0,0 +1,80 @@
+package opc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type Relationships struct {
+	XMLName       xml.Name        `xml:"http://schemas.openxmlformats.org/package/2006/relationships Relationships"`
+	Relationships []*Relationship `xml:"Relationship"`
+}
+
+type Relationship struct {
+	ID         string `xml:"Id,attr"`
+	Type       string `xml:"Type,attr"`
+	Target     string `xml:"Target,attr"`
+	TargetMode string `xml:"TargetMode,attr,omitempty"`
+}
+
+// Reports whether the target is outside of the package (e.g. a URL)
+func (r *Relationship) IsExternal() bool {
+	return strings.EqualFold(r.TargetMode, "External")
+}
+
+// Reads the relationships of a part (_rels/<name>.rels next to it), the package relationships if source is empty.
+// Returns fs.ErrNotExist if the part has no relationships part.
+func (pkg *Package) Relationships(source string) (*Relationships, error) {
+	name := RelationshipsPartName(source)
+	data, err := pkg.ReadPart(name)
+	if err != nil {
+		return nil, err
+	}
+	var relationships Relationships
+	if err = xml.Unmarshal(data, &relationships); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &relationships, nil
+}
+
+// Returns the part targeted by the first internal relationship of the given type of the source part
+// (package relationships if empty), nil if there is none or its target is missing
+func (pkg *Package) RelatedPart(source string, relType string) *Part {
+	relationships, err := pkg.Relationships(source)
+	if err != nil {
+		return nil
+	}
+	for _, r := range relationships.Relationships {
+		if r.Type != relType || r.IsExternal() {
+			continue
+		}
+		if p := pkg.Part(ResolveTarget(source, r.Target)); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// Name of the relationships part of a part, _rels/.rels for the package (empty part name)
+func RelationshipsPartName(source string) string {
+	source = strings.TrimPrefix(source, "/")
+	if source == "" {
+		return "_rels/.rels"
+	}
+	return path.Join(path.Dir(source), "_rels", path.Base(source)+".rels")
+}
+
+// Resolves the target of an internal relationship to a part name without leading slash,
+// relative targets are resolved against the folder of the source part
+func ResolveTarget(source string, target string) string {
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(path.Clean(target), "/")
+	}
+	return strings.TrimPrefix(path.Join("/", path.Dir(strings.TrimPrefix(source, "/")), target), "/")
+}
@@ -0,0 +1,140 @@
+package opc
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Writes the package. Unchanged parts are copied raw (headers and compressed data) in their original order. Changed parts
+// keep their position and header (compression, times, extra fields, comment), new parts are appended in the order of
+// their names. If modified is not zero, it is the modification time of all changed and new parts.
+func (pkg *Package) Write(w io.Writer, modified time.Time) error {
+	zipWriter := zip.NewWriter(w)
+	if err := zipWriter.SetComment(pkg.zip.Comment); err != nil {
+		return err
+	}
+	var added []*Part
+	for _, p := range pkg.parts {
+		switch {
+		case p.file == nil:
+			added = append(added, p)
+		case p.data == nil:
+			if err := zipWriter.Copy(p.file); err != nil {
+				return err
+			}
+		default:
+			header := rewrittenHeader(p.file)
+			if !modified.IsZero() {
+				header.Modified = modified
+			}
+			if err := writePart(zipWriter, header, p.data); err != nil {
+				return err
+			}
+		}
+	}
+	slices.SortStableFunc(added, func(a, b *Part) int { return strings.Compare(a.Name, b.Name) })
+	for _, p := range added {
+		if err := writePart(zipWriter, &zip.FileHeader{Name: p.Name, Method: zip.Deflate, Modified: modified}, p.data); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// Writes a part with the compression method of the header (Deflate unless Store). The data is compressed here, so the
+// sizes and the CRC-32 are written to the local header instead of a data descriptor, like Office does. unzip rejects
+// archives mixing entries with data descriptors and entries with Unicode path extra fields as overlapping.
+func writePart(zipWriter *zip.Writer, header *zip.FileHeader, data []byte) error {
+	var compressed bytes.Buffer
+	if header.Method == zip.Store {
+		compressed.Write(data)
+	} else {
+		header.Method = zip.Deflate
+		fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(data); err != nil {
+			return err
+		}
+		if err = fw.Close(); err != nil {
+			return err
+		}
+	}
+	header.Flags &^= dataDescriptorFlag
+	if !header.NonUTF8 && utf8.ValidString(header.Name) && strings.ContainsFunc(header.Name, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		header.Flags |= utf8Flag
+	}
+	header.CRC32 = crc32.ChecksumIEEE(data)
+	header.CompressedSize64 = uint64(compressed.Len())
+	header.UncompressedSize64 = uint64(len(data))
+	header.CreatorVersion = header.CreatorVersion&0xff00 | zipVersion20
+	header.ReaderVersion = zipVersion20
+	if !header.Modified.IsZero() {
+		// As written by zip.Writer.CreateHeader: MS-DOS time and an extended timestamp
+		header.ModifiedDate, header.ModifiedTime = msDosTime(header.Modified)
+		header.Extra = binary.LittleEndian.AppendUint16(header.Extra, extTimeExtraID)
+		header.Extra = binary.LittleEndian.AppendUint16(header.Extra, 5)
+		header.Extra = append(header.Extra, 1) // modification time only
+		header.Extra = binary.LittleEndian.AppendUint32(header.Extra, uint32(header.Modified.Unix()))
+	}
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(compressed.Bytes())
+	return err
+}
+
+func msDosTime(t time.Time) (date uint16, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+const (
+	dataDescriptorFlag = 0x8
+	utf8Flag           = 0x800
+	zipVersion20       = 20
+)
+
+// Extra field IDs the zip writer creates itself: sizes of large entries and the modification time
+const (
+	zip64ExtraID   = 0x0001
+	extTimeExtraID = 0x5455
+)
+
+// Header of an entry written with new data. The zip64 and extended timestamp extra fields are removed, the writer
+// recreates them; without an extended timestamp only the MS-DOS time of the original header is written.
+func rewrittenHeader(entry *zip.File) *zip.FileHeader {
+	header := entry.FileHeader
+	var extra []byte
+	hasExtTime := false
+	for b := entry.Extra; len(b) >= 4; {
+		id, size := binary.LittleEndian.Uint16(b), int(binary.LittleEndian.Uint16(b[2:]))
+		if 4+size > len(b) {
+			break
+		}
+		switch id {
+		case zip64ExtraID:
+		case extTimeExtraID:
+			hasExtTime = true
+		default:
+			extra = append(extra, b[:4+size]...)
+		}
+		b = b[4+size:]
+	}
+	header.Extra = extra
+	if !hasExtTime {
+		header.Modified = time.Time{}
+	}
+	return &header
+}
@@ -0,0 +1,188 @@
+package opc
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Package with stored and deflated entries, a data descriptor, an extra field, comments and a Unicode name
+func testPackage(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	modified := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	for _, entry := range []struct {
+		header *zip.FileHeader
+		data   string
+	}{
+		{&zip.FileHeader{Name: ContentTypesName, Method: zip.Deflate, Modified: modified}, `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`},
+		{&zip.FileHeader{Name: "_rels/.rels", Method: zip.Store}, `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"/>`},
+		{&zip.FileHeader{Name: "xl/workbook.xml", Method: zip.Deflate, Comment: "workbook", Extra: []byte{0xCA, 0xFE, 2, 0, 1, 2}}, "<workbook/>"},
+		{&zip.FileHeader{Name: "xl/media/bildä.png", Method: zip.Store, Modified: modified}, "\x89PNG"},
+		{&zip.FileHeader{Name: "xl/vbaProject.bin", Method: zip.Deflate}, string(bytes.Repeat([]byte("vba"), 1000))},
+	} {
+		w, err := zw.CreateHeader(entry.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.SetComment("package comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openZip(t *testing.T, data []byte) *zip.Reader {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+// Compressed data of an entry
+func rawData(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+	r, err := f.OpenRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readEntry(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", f.Name, err)
+	}
+	return data
+}
+
+// Checks that the entry was copied unchanged: compressed data, CRC-32 and header fields
+func checkCopied(t *testing.T, got, want *zip.File) {
+	t.Helper()
+	if !bytes.Equal(rawData(t, got), rawData(t, want)) {
+		t.Errorf("%s: compressed data differs", want.Name)
+	}
+	if got.Method != want.Method || got.CRC32 != want.CRC32 || got.CompressedSize64 != want.CompressedSize64 ||
+		got.UncompressedSize64 != want.UncompressedSize64 || got.ModifiedDate != want.ModifiedDate ||
+		got.ModifiedTime != want.ModifiedTime || got.Comment != want.Comment || !bytes.Equal(got.Extra, want.Extra) {
+		t.Errorf("%s: header %+v, want %+v", want.Name, got.FileHeader, want.FileHeader)
+	}
+}
+
+func TestWriteUnchanged(t *testing.T) {
+	for _, name := range []string{"generated", "Book1.xlsm"} {
+		t.Run(name, func(t *testing.T) {
+			data := testPackage(t)
+			if name != "generated" {
+				var err error
+				if data, err = os.ReadFile("../testdata/" + name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pkg, err := Open(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := pkg.Write(&buf, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			want, got := openZip(t, data), openZip(t, buf.Bytes())
+			if len(got.File) != len(want.File) || got.Comment != want.Comment {
+				t.Fatalf("%d entries with comment %q, want %d with %q", len(got.File), got.Comment, len(want.File), want.Comment)
+			}
+			for i, f := range want.File {
+				if got.File[i].Name != f.Name {
+					t.Errorf("entry %d is %s, want %s", i, got.File[i].Name, f.Name)
+					continue
+				}
+				checkCopied(t, got.File[i], f)
+			}
+		})
+	}
+}
+
+func TestWriteChanged(t *testing.T) {
+	data := testPackage(t)
+	pkg, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, 2, 29, 23, 59, 58, 0, time.UTC)
+	pkg.SetPart("/_rels/.RELS", []byte("<Relationships/>"))
+	pkg.SetPart("xl/workbook.xml", []byte("<workbook><sheets/></workbook>"))
+	pkg.RemovePart("xl/media/bildä.png")
+	pkg.SetPart("xl/vbaProjectSignature.bin", []byte("signature"))
+	pkg.SetPart("docProps/app.xml", []byte("<Properties/>"))
+	var buf bytes.Buffer
+	if err := pkg.Write(&buf, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := openZip(t, data), openZip(t, buf.Bytes())
+	var names []string
+	for _, f := range got.File {
+		names = append(names, f.Name)
+	}
+	// Changed parts keep their position and name, new parts are appended sorted by name
+	wantNames := []string{ContentTypesName, "_rels/.rels", "xl/workbook.xml", "xl/vbaProject.bin", "docProps/app.xml", "xl/vbaProjectSignature.bin"}
+	if !slices.Equal(names, wantNames) {
+		t.Fatalf("entries %q, want %q", names, wantNames)
+	}
+	checkCopied(t, got.File[0], want.File[0])
+	checkCopied(t, got.File[3], want.File[4])
+
+	for _, test := range []struct {
+		f       *zip.File
+		data    string
+		method  uint16
+		comment string
+	}{
+		{got.File[1], "<Relationships/>", zip.Store, ""},
+		{got.File[2], "<workbook><sheets/></workbook>", zip.Deflate, "workbook"},
+		{got.File[4], "<Properties/>", zip.Deflate, ""},
+		{got.File[5], "signature", zip.Deflate, ""},
+	} {
+		f := test.f
+		if data := readEntry(t, f); string(data) != test.data {
+			t.Errorf("%s: %q, want %q", f.Name, data, test.data)
+		}
+		if f.Method != test.method || f.Comment != test.comment {
+			t.Errorf("%s: method %d with comment %q, want %d with %q", f.Name, f.Method, f.Comment, test.method, test.comment)
+		}
+		if !f.Modified.Equal(modified) {
+			t.Errorf("%s: modified %s, want %s", f.Name, f.Modified, modified)
+		}
+		if f.Flags&dataDescriptorFlag != 0 {
+			t.Errorf("%s: written with a data descriptor", f.Name)
+		}
+	}
+	// Extra fields other than the timestamp are kept
+	if !bytes.Contains(got.File[2].Extra, []byte{0xCA, 0xFE, 2, 0, 1, 2}) {
+		t.Errorf("extra field of xl/workbook.xml lost: %X", got.File[2].Extra)
+	}
+}
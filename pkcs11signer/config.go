@@ -0,0 +1,52 @@
+// Package pkcs11signer provides a crypto.Signer for keys held in a PKCS#11 token (e.g. a HSM or SoftHSM),
+// to be used with vbaproject.NewIdentity for all VBA signature versions.
+package pkcs11signer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Location of the signing key in the PKCS#11 token
+type Config struct {
+	Module     string // path to the PKCS#11 module (e.g. /usr/lib/softhsm/libsofthsm2.so)
+	TokenLabel string // label of the token, if empty the first token present is used
+	KeyLabel   string // label (CKA_LABEL) of the private key
+	KeyID      []byte // ID (CKA_ID) of the private key, at least one of KeyLabel and KeyID is required
+	PIN        string // user PIN, see ReadPIN
+}
+
+// Reads the PIN from an environment variable or from the first line of a file (e.g. a mounted secret)
+func ReadPIN(envVar string, pinFile string) (string, error) {
+	if envVar != "" && pinFile != "" {
+		return "", errors.New("pkcs11signer: PIN from environment and file are mutually exclusive")
+	}
+	if envVar != "" {
+		pin, ok := os.LookupEnv(envVar)
+		if !ok {
+			return "", fmt.Errorf("pkcs11signer: environment variable %s not set", envVar)
+		}
+		return pin, nil
+	}
+	if pinFile != "" {
+		b, err := os.ReadFile(pinFile)
+		if err != nil {
+			return "", fmt.Errorf("pkcs11signer: reading PIN failed: %w", err)
+		}
+		pin, _, _ := strings.Cut(string(b), "\n")
+		return strings.TrimRight(pin, "\r"), nil
+	}
+	return "", errors.New("pkcs11signer: no PIN source given")
+}
+
+func (cfg Config) validate() error {
+	if cfg.Module == "" {
+		return errors.New("pkcs11signer: module path required")
+	}
+	if cfg.KeyLabel == "" && len(cfg.KeyID) == 0 {
+		return errors.New("pkcs11signer: key label or key ID required")
+	}
+	return nil
+}
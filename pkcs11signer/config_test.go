@@ -0,0 +1,87 @@
+package pkcs11signer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadPIN(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	t.Setenv("VBASIG_TEST_PIN", "1234")
+	t.Setenv("VBASIG_TEST_EMPTY_PIN", "")
+
+	tests := []struct {
+		name    string
+		envVar  string
+		pinFile string
+		pin     string
+		err     string
+	}{
+		{"environment", "VBASIG_TEST_PIN", "", "1234", ""},
+		{"empty environment", "VBASIG_TEST_EMPTY_PIN", "", "", ""},
+		{"unset environment", "VBASIG_TEST_UNSET_PIN", "", "", "not set"},
+		{"file", "", writeFile("pin", "5678"), "5678", ""},
+		{"file first line", "", writeFile("pin-lf", "5678\nignored\n"), "5678", ""},
+		{"file CRLF", "", writeFile("pin-crlf", "5678\r\n"), "5678", ""},
+		{"missing file", "", filepath.Join(dir, "missing"), "", "reading PIN failed"},
+		{"both", "VBASIG_TEST_PIN", writeFile("pin-both", "5678"), "", "mutually exclusive"},
+		{"none", "", "", "", "no PIN source"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pin, err := ReadPIN(test.envVar, test.pinFile)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pin != test.pin {
+				t.Errorf("got PIN %q, want %q", pin, test.pin)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string
+	}{
+		{"key label", Config{Module: "libsofthsm2.so", KeyLabel: "signkey"}, ""},
+		{"key ID", Config{Module: "libsofthsm2.so", KeyID: []byte{1}}, ""},
+		{"label and ID", Config{Module: "libsofthsm2.so", KeyLabel: "signkey", KeyID: []byte{1}}, ""},
+		{"no module", Config{KeyLabel: "signkey"}, "module path required"},
+		{"no key", Config{Module: "libsofthsm2.so", TokenLabel: "vbasig"}, "key label or key ID required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.validate()
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{Module: "libsofthsm2.so"}); err == nil {
+		t.Fatal("New accepted a config without key")
+	}
+}
@@ -0,0 +1,369 @@
+//go:build cgo
+
+package pkcs11signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// crypto.Signer for a private key in a PKCS#11 token, the session stays open until Close is called
+type Signer struct {
+	ctx     *pkcs11.Ctx
+	module  *module
+	slot    uint
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	keyID   []byte
+	keyType uint
+	public  crypto.PublicKey
+	mu      sync.Mutex // a PKCS#11 session must not be used concurrently
+}
+
+// Loads the PKCS#11 module, logs into the token and looks up the private key
+func New(cfg Config) (s *Signer, err error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	m, err := openModule(cfg.Module)
+	if err != nil {
+		return nil, err
+	}
+	ctx := m.ctx
+	s = &Signer{ctx: ctx, module: m}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	slot, err := findSlot(ctx, cfg.TokenLabel)
+	if err != nil {
+		return nil, err
+	}
+	s.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: opening session failed: %w", err)
+	}
+	s.slot = slot
+	m.addSession(slot)
+	if err = ctx.Login(s.session, pkcs11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return nil, fmt.Errorf("pkcs11signer: login failed: %w", err)
+	}
+
+	// Private key
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)}
+	if cfg.KeyLabel != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel))
+	}
+	if len(cfg.KeyID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, cfg.KeyID))
+	}
+	s.key, err = s.findObject(template)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: private key: %w", err)
+	}
+	attrs, err := ctx.GetAttributeValue(s.session, s.key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: reading key attributes failed: %w", err)
+	}
+	s.keyType = ulong(attrs[0].Value)
+	s.keyID = attrs[1].Value
+
+	// Public key, needed to choose the signature algorithm and to match the certificate
+	switch s.keyType {
+	case pkcs11.CKK_RSA:
+		s.public, err = s.rsaPublicKey()
+	case pkcs11.CKK_EC:
+		s.public, err = s.ecdsaPublicKey()
+	default:
+		err = fmt.Errorf("pkcs11signer: unsupported key type 0x%x", s.keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Signs a digest, RSA keys use PKCS#1 v1.5 (CKM_RSA_PKCS), EC keys use CKM_ECDSA with an ASN.1 encoded result
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	data := digest
+	switch s.keyType {
+	case pkcs11.CKK_RSA:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("pkcs11signer: RSA-PSS not supported")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("pkcs11signer: unsupported hash %v", opts.HashFunc())
+		}
+		data = append(append([]byte{}, prefix...), digest...)
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	case pkcs11.CKK_EC:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{mechanism}, s.key); err != nil {
+		return nil, fmt.Errorf("pkcs11signer: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: %w", err)
+	}
+	if s.keyType == pkcs11.CKK_EC {
+		// PKCS#11 returns r || s
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+	}
+	return sig, nil
+}
+
+// Returns the certificate stored in the token with the same ID as the private key
+func (s *Signer) Certificate() (*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, err := s.findObject([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, s.keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: certificate: %w", err)
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: reading certificate failed: %w", err)
+	}
+	return x509.ParseCertificate(attrs[0].Value)
+}
+
+// Closes the session, logs out if it was the last session of the process on the token and releases the module.
+// The module is shared by all signers of the process and finalized when the last one is closed.
+func (s *Signer) Close() error {
+	if s.module == nil {
+		return nil
+	}
+	if s.session != 0 {
+		if s.module.removeSession(s.slot) {
+			s.ctx.Logout(s.session)
+		}
+		s.ctx.CloseSession(s.session)
+		s.session = 0
+	}
+	err := s.module.release()
+	s.module, s.ctx = nil, nil
+	return err
+}
+
+// PKCS#11 modules loaded by the signers of the process by path. A module is initialized once per process,
+// finalizing it ends the sessions of all signers, and the login state is shared by all sessions on a token.
+var (
+	modulesMu sync.Mutex
+	modules   = map[string]*module{}
+)
+
+type module struct {
+	path     string
+	ctx      *pkcs11.Ctx
+	refs     int          // signers using the module
+	sessions map[uint]int // open sessions by slot
+	finalize bool         // initialized by this package, not by another user of the module in the process
+}
+
+// Returns the loaded module or loads and initializes it
+func openModule(path string) (*module, error) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if m, ok := modules[path]; ok {
+		m.refs++
+		return m, nil
+	}
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11signer: loading module %s failed", path)
+	}
+	m := &module{path: path, ctx: ctx, refs: 1, sessions: map[uint]int{}, finalize: true}
+	if err := ctx.Initialize(); errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		m.finalize = false
+	} else if err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11signer: initializing module failed: %w", err)
+	}
+	modules[path] = m
+	return m, nil
+}
+
+func (m *module) addSession(slot uint) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	m.sessions[slot]++
+}
+
+// Reports whether the session was the last one on the slot
+func (m *module) removeSession(slot uint) bool {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	m.sessions[slot]--
+	return m.sessions[slot] == 0
+}
+
+// Finalizes and unloads the module when it is no longer used
+func (m *module) release() error {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if m.refs--; m.refs > 0 {
+		return nil
+	}
+	delete(modules, m.path)
+	var err error
+	if m.finalize {
+		err = m.ctx.Finalize()
+	}
+	m.ctx.Destroy()
+	return err
+}
+
+// Returns the slot of the token with the given label, or of the first token if the label is empty
+func findSlot(ctx *pkcs11.Ctx, tokenLabel string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11signer: listing slots failed: %w", err)
+	}
+	for _, slot := range slots {
+		if tokenLabel == "" {
+			return slot, nil
+		}
+		ti, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimRight(ti.Label, " \x00") == tokenLabel {
+			return slot, nil
+		}
+	}
+	if tokenLabel == "" {
+		return 0, errors.New("pkcs11signer: no token present")
+	}
+	return 0, fmt.Errorf("pkcs11signer: token %q not found", tokenLabel)
+}
+
+// Returns the single object matching the template
+func (s *Signer) findObject(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	objs, _, err := s.ctx.FindObjects(s.session, 2)
+	if ferr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch len(objs) {
+	case 0:
+		return 0, errors.New("not found")
+	case 1:
+		return objs[0], nil
+	}
+	return 0, errors.New("ambiguous, several objects match")
+}
+
+func (s *Signer) rsaPublicKey() (*rsa.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, s.key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: reading RSA public key failed: %w", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attrs[0].Value),
+		E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+	}, nil
+}
+
+// EC points are only available from the public key object with the same ID
+func (s *Signer) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	obj, err := s.findObject([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, s.keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: EC public key: %w", err)
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11signer: reading EC public key failed: %w", err)
+	}
+	var curveOid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(attrs[0].Value, &curveOid); err != nil {
+		return nil, fmt.Errorf("pkcs11signer: parsing EC parameters failed: %w", err)
+	}
+	var curve elliptic.Curve
+	switch {
+	case curveOid.Equal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}):
+		curve = elliptic.P256()
+	case curveOid.Equal(asn1.ObjectIdentifier{1, 3, 132, 0, 34}):
+		curve = elliptic.P384()
+	case curveOid.Equal(asn1.ObjectIdentifier{1, 3, 132, 0, 35}):
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("pkcs11signer: unsupported curve %s", curveOid)
+	}
+	// CKA_EC_POINT is a DER encoded OCTET STRING containing the uncompressed point
+	// (some tokens return the plain point)
+	point := attrs[1].Value
+	if rest, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil || len(rest) > 0 {
+		point = attrs[1].Value
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("pkcs11signer: invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// DER encoded DigestInfo prefixes for PKCS#1 v1.5 signatures (RFC 8017, 9.2)
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.MD5:    {0x30, 0x20, 0x30, 0x0c, 0x06, 0x08, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x02, 0x05, 0x05, 0x00, 0x04, 0x10},
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// CK_ULONG attributes are returned in native byte order
+func ulong(b []byte) uint {
+	switch len(b) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+//go:build !cgo
+
+package pkcs11signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"io"
+)
+
+var errNoCgo = errors.New("pkcs11signer: PKCS#11 support requires a build with cgo")
+
+// crypto.Signer for a private key in a PKCS#11 token, not available in builds without cgo
+type Signer struct{}
+
+func New(cfg Config) (*Signer, error) {
+	return nil, errNoCgo
+}
+
+func (s *Signer) Public() crypto.PublicKey {
+	return nil
+}
+
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, errNoCgo
+}
+
+func (s *Signer) Certificate() (*x509.Certificate, error) {
+	return nil, errNoCgo
+}
+
+func (s *Signer) Close() error {
+	return nil
+}
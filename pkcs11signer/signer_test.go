@@ -0,0 +1,193 @@
+//go:build cgo
+
+package pkcs11signer_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/pkcs11signer"
+	"github.com/coffeeforyou/vbasig/vbaproject"
+)
+
+const (
+	tokenLabel = "vbasig"
+	userPIN    = "1234"
+)
+
+// Creates a SoftHSM token in a temporary directory and imports an RSA and an ECDSA key.
+// Skipped unless SOFTHSM2_MODULE points to libsofthsm2.so and softhsm2-util is installed.
+func softHSM(t *testing.T) string {
+	t.Helper()
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE not set")
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if err != nil {
+		t.Skip("softhsm2-util not found")
+	}
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	run := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command(util, args...).CombinedOutput(); err != nil {
+			t.Fatalf("softhsm2-util %v: %v\n%s", args, err, out)
+		}
+	}
+	run("--init-token", "--free", "--label", tokenLabel, "--so-pin", "0000", "--pin", userPIN)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []struct {
+		label, id string
+		key       crypto.PrivateKey
+	}{{"rsakey", "01", rsaKey}, {"eckey", "02", ecKey}} {
+		der, err := x509.MarshalPKCS8PrivateKey(k.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, k.label+".p8")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		run("--import", path, "--token", tokenLabel, "--label", k.label, "--id", k.id, "--pin", userPIN)
+	}
+	return module
+}
+
+// Self-signed code signing certificate, signed through the token
+func selfSignedCertificate(t *testing.T, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vbasig PKCS#11 test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func signAndVerify(t *testing.T, signer *pkcs11signer.Signer, so vbaproject.SignOptions) {
+	t.Helper()
+	doc, err := os.ReadFile("../testdata/Book1.xlsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := vbaproject.NewIdentity(signer, []*x509.Certificate{selfSignedCertificate(t, signer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed bytes.Buffer
+	if err := vbaproject.SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), ".xlsm", id, so); err != nil {
+		t.Fatal(err)
+	}
+	results, err := vbaproject.VerifyDocument(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, included := range []bool{so.IncludeV1, so.IncludeAgile, so.IncludeV3} {
+		if included {
+			want++
+		}
+	}
+	if len(results) != want {
+		t.Fatalf("%d signatures verified, want %d", len(results), want)
+	}
+	for _, r := range results {
+		if !r.Valid {
+			t.Errorf("%s", r)
+		}
+	}
+}
+
+func TestSignRSA(t *testing.T) {
+	module := softHSM(t)
+	signer, err := pkcs11signer.New(pkcs11signer.Config{Module: module, TokenLabel: tokenLabel, KeyLabel: "rsakey", PIN: userPIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		t.Fatalf("public key %T, want RSA", signer.Public())
+	}
+	signAndVerify(t, signer, vbaproject.SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true})
+}
+
+func TestSignECDSA(t *testing.T) {
+	module := softHSM(t)
+	signer, err := pkcs11signer.New(pkcs11signer.Config{Module: module, TokenLabel: tokenLabel, KeyID: []byte{0x02}, PIN: userPIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		t.Fatalf("public key %T, want ECDSA", signer.Public())
+	}
+	// The V1 signature uses MD5 with RSA only
+	signAndVerify(t, signer, vbaproject.SignOptions{IncludeAgile: true, IncludeV3: true})
+}
+
+func TestSignersShareModule(t *testing.T) {
+	module := softHSM(t)
+	cfg := pkcs11signer.Config{Module: module, TokenLabel: tokenLabel, KeyLabel: "rsakey", PIN: userPIN}
+	first, err := pkcs11signer.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pkcs11signer.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing one signer must leave the module and the login of the other intact
+	signAndVerify(t, second, vbaproject.SignOptions{IncludeV3: true})
+}
+
+func TestWrongPIN(t *testing.T) {
+	module := softHSM(t)
+	if _, err := pkcs11signer.New(pkcs11signer.Config{Module: module, TokenLabel: tokenLabel, KeyLabel: "rsakey", PIN: "0000"}); err == nil {
+		t.Fatal("login with a wrong PIN succeeded")
+	}
+}
@@ -0,0 +1,48 @@
+package pkcs7
+
+import (
+	"errors"
+)
+
+// SignedAttributes returns the DER encoding of the authenticated attributes of the only signer,
+// which is the data the signature value is computed over (e.g. by a signer on another machine)
+func (p7 *PKCS7) SignedAttributes() ([]byte, error) {
+	if len(p7.Signers) != 1 {
+		return nil, errors.New("pkcs7: signed attributes require exactly one signer")
+	}
+	if len(p7.Signers[0].AuthenticatedAttributes) == 0 {
+		return nil, errors.New("pkcs7: signer has no authenticated attributes")
+	}
+	return marshalAttributes(p7.Signers[0].AuthenticatedAttributes)
+}
+
+// ReplaceSigners replaces the signers, digest algorithms and certificates with those of
+// another signed data over the same content, e.g. a detached signature created by another tool.
+// The content is kept, Verify tells whether the signers actually signed it. Certificates of both are kept.
+func (p7 *PKCS7) ReplaceSigners(other *PKCS7) error {
+	sd, ok := p7.raw.(signedData)
+	if !ok {
+		return errors.New("pkcs7: only signed data can be modified")
+	}
+	osd, ok := other.raw.(signedData)
+	if !ok {
+		return errors.New("pkcs7: signers can only be taken from signed data")
+	}
+	if len(osd.SignerInfos) == 0 {
+		return errors.New("pkcs7: no signers")
+	}
+	certs, err := sd.Certificates.Parse()
+	if err != nil {
+		return err
+	}
+	sd.DigestAlgorithmIdentifiers = osd.DigestAlgorithmIdentifiers
+	sd.SignerInfos = osd.SignerInfos
+	sd.Certificates = osd.Certificates
+	if err = sd.addCertificates(certs); err != nil {
+		return err
+	}
+	p7.Signers = sd.SignerInfos
+	p7.Certificates, _ = sd.Certificates.Parse()
+	p7.raw = sd
+	return nil
+}
@@ -0,0 +1,434 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// 1.3.6.1.4.1.311.3.3.1 szOID_RFC3161_counterSign, unsigned attribute holding a RFC 3161 timestamp token (Microsoft code signing)
+	OIDAttributeRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+	// 1.2.840.113549.1.9.16.1.4 id-ct-TSTInfo
+	OIDTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	// 1.2.840.113549.1.9.6 countersignature, unsigned attribute holding the signer info of a legacy Authenticode timestamp
+	OIDAttributeCountersignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	// 1.3.6.1.4.1.311.3.2.1 SPC_TIME_STAMP_REQUEST_OBJID, legacy Authenticode timestamp request
+	OIDAuthenticodeTimestampRequest = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 2, 1}
+)
+
+// TimestampClient requests RFC 3161 timestamp tokens or legacy Authenticode countersignatures from a time stamping authority (TSA)
+type TimestampClient struct {
+	URL        string
+	HTTPClient *http.Client // http.DefaultClient if nil
+	Hash       crypto.Hash  // hash of the message imprint, SHA256 if zero (RFC 3161 only, the hash of a countersignature is chosen by the TSA)
+	Legacy     bool         // use the legacy Authenticode protocol (PKCS#9 countersignature) instead of RFC 3161
+}
+
+// SignerTimestamp is a verified timestamp of a signer, see VerifyTimestamps
+type SignerTimestamp struct {
+	Time   time.Time
+	Legacy bool              // legacy Authenticode countersignature instead of RFC 3161 token
+	TSA    *x509.Certificate // certificate of the time stamping authority
+}
+
+// TimestampInfo is the content (TSTInfo) of a timestamp token
+type TimestampInfo struct {
+	Time          time.Time
+	HashAlgorithm asn1.ObjectIdentifier
+	HashedMessage []byte
+	SerialNumber  *big.Int
+	Nonce         *big.Int
+	Token         *PKCS7 // signed data of the TSA
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type authenticodeTimestampReq struct {
+	CountersignatureType asn1.ObjectIdentifier
+	Content              contentInfo
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       accuracy      `asn1:"optional"`
+	Ordering       bool          `asn1:"optional,default:false"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"explicit,optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// RequestToken requests a timestamp token for data, the token is returned DER encoded (ContentInfo with SignedData)
+func (c *TimestampClient) RequestToken(data []byte) ([]byte, error) {
+	hash := c.Hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	hashOid, err := getOIDForHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(data)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req := timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: hashOid, Parameters: asn1.NullRawValue}, HashedMessage: h.Sum(nil)},
+		Nonce:          nonce,
+		CertReq:        true,
+	}
+	reqBytes, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := c.post("application/timestamp-query", reqBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(respBytes, &resp); err != nil {
+		return nil, fmt.Errorf("pkcs7: parsing timestamp response failed: %w", err)
+	}
+	// 0 granted, 1 grantedWithMods
+	if resp.Status.Status > 1 {
+		return nil, fmt.Errorf("pkcs7: timestamp request rejected with status %d", resp.Status.Status)
+	}
+	if len(resp.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("pkcs7: timestamp response contains no token")
+	}
+	info, err := ParseTimestampToken(resp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.HashedMessage, req.MessageImprint.HashedMessage) {
+		return nil, errors.New("pkcs7: timestamp token does not match the request")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("pkcs7: timestamp token nonce does not match the request")
+	}
+	return resp.TimeStampToken.FullBytes, nil
+}
+
+// RequestCountersignature requests a legacy Authenticode timestamp for data (the encrypted digest of a signer).
+// The response of the TSA is returned, its content is data and its signer is the countersignature.
+func (c *TimestampClient) RequestCountersignature(data []byte) (*PKCS7, error) {
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	reqBytes, err := asn1.Marshal(authenticodeTimestampReq{
+		CountersignatureType: OIDAuthenticodeTimestampRequest,
+		Content:              contentInfo{ContentType: OIDData, Content: asn1.RawValue{Class: 2, Tag: 0, Bytes: content, IsCompound: true}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Request and response are base64 encoded
+	respBytes, err := c.post("application/octet-stream", []byte(base64.StdEncoding.EncodeToString(reqBytes)))
+	if err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(respBytes)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: decoding countersignature response failed: %w", err)
+	}
+	p7, err := Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: parsing countersignature response failed: %w", err)
+	}
+	if len(p7.Signers) != 1 {
+		return nil, errors.New("pkcs7: countersignature response must contain exactly one signer")
+	}
+	if !bytes.Equal(p7.Content, data) {
+		return nil, errors.New("pkcs7: countersignature does not match the request")
+	}
+	return p7, nil
+}
+
+func (c *TimestampClient) post(contentType string, body []byte) ([]byte, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Post(c.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: timestamp request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pkcs7: timestamp request failed: %s", httpResp.Status)
+	}
+	respBytes, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: reading timestamp response failed: %w", err)
+	}
+	return respBytes, nil
+}
+
+// ParseTimestampToken parses a DER encoded RFC 3161 timestamp token, the signature of the TSA is not verified
+func ParseTimestampToken(token []byte) (*TimestampInfo, error) {
+	p7, err := Parse(token)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs7: parsing timestamp token failed: %w", err)
+	}
+	sd, ok := p7.raw.(signedData)
+	if !ok || !sd.ContentInfo.ContentType.Equal(OIDTSTInfo) {
+		return nil, errors.New("pkcs7: timestamp token does not contain TSTInfo")
+	}
+	var tst tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &tst); err != nil {
+		return nil, fmt.Errorf("pkcs7: parsing TSTInfo failed: %w", err)
+	}
+	return &TimestampInfo{
+		Time:          tst.GenTime,
+		HashAlgorithm: tst.MessageImprint.HashAlgorithm.Algorithm,
+		HashedMessage: tst.MessageImprint.HashedMessage,
+		SerialNumber:  tst.SerialNumber,
+		Nonce:         tst.Nonce,
+		Token:         p7,
+	}, nil
+}
+
+// AddTimestamps requests a timestamp for the encrypted digest of each signer and stores it as unauthenticated
+// attribute, replacing existing ones: a RFC 3161 token (1.3.6.1.4.1.311.3.3.1) or, for legacy clients,
+// a countersignature (1.2.840.113549.1.9.6) whose certificates are added to the signed data
+func (p7 *PKCS7) AddTimestamps(c *TimestampClient) error {
+	sd, ok := p7.raw.(signedData)
+	if !ok {
+		return errors.New("pkcs7: timestamps can only be added to signed data")
+	}
+	for i := range sd.SignerInfos {
+		if !c.Legacy {
+			token, err := c.RequestToken(sd.SignerInfos[i].EncryptedDigest)
+			if err != nil {
+				return err
+			}
+			if err := sd.SignerInfos[i].setUnauthenticatedAttribute(OIDAttributeRFC3161Timestamp, asn1.RawValue{FullBytes: token}); err != nil {
+				return err
+			}
+			continue
+		}
+		resp, err := c.RequestCountersignature(sd.SignerInfos[i].EncryptedDigest)
+		if err != nil {
+			return err
+		}
+		if err := sd.SignerInfos[i].setUnauthenticatedAttribute(OIDAttributeCountersignature, resp.Signers[0]); err != nil {
+			return err
+		}
+		if err := sd.addCertificates(resp.Certificates); err != nil {
+			return err
+		}
+	}
+	p7.Signers = sd.SignerInfos
+	p7.Certificates, _ = sd.Certificates.Parse()
+	p7.raw = sd
+	return nil
+}
+
+// Marshal encodes the (possibly modified) signed data, e.g. after adding timestamps
+func (p7 *PKCS7) Marshal() ([]byte, error) {
+	sd, ok := p7.raw.(signedData)
+	if !ok {
+		return nil, errors.New("pkcs7: only signed data can be marshalled")
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	outer := contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: 2, Tag: 0, Bytes: inner, IsCompound: true},
+	}
+	return asn1.Marshal(outer)
+}
+
+// Sets an unauthenticated attribute, replacing attributes of the same type
+func (si *signerInfo) setUnauthenticatedAttribute(attrType asn1.ObjectIdentifier, value interface{}) error {
+	asn1Value, err := asn1.Marshal(value)
+	if err != nil {
+		return err
+	}
+	// DER requires the elements of a SET OF in ascending order of their encoding
+	var sortables attributeSet
+	for _, attr := range si.UnauthenticatedAttributes {
+		if !attr.Type.Equal(attrType) {
+			sortables = append(sortables, sortableAttribute{Attribute: attr})
+		}
+	}
+	sortables = append(sortables, sortableAttribute{Attribute: attribute{Type: attrType, Value: asn1.RawValue{Tag: 17, IsCompound: true, Bytes: asn1Value}}})
+	for i := range sortables {
+		if sortables[i].SortKey, err = asn1.Marshal(sortables[i].Attribute); err != nil {
+			return err
+		}
+	}
+	sort.Sort(sortables)
+	si.UnauthenticatedAttributes = sortables.Attributes()
+	return nil
+}
+
+// VerifyTimestamps verifies the timestamps (RFC 3161 tokens or legacy countersignatures) of all signers and returns
+// one entry per signer, nil if the signer has no timestamp. A timestamp must cover the encrypted digest of its signer
+// and be signed with a time stamping certificate valid at the time of the timestamp.
+// If truststore is not nil, the chain of the TSA is verified at the time of the timestamp as well.
+func (p7 *PKCS7) VerifyTimestamps(truststore *x509.CertPool) ([]*SignerTimestamp, error) {
+	res := make([]*SignerTimestamp, len(p7.Signers))
+	for i, signer := range p7.Signers {
+		ts, err := verifySignerTimestamp(p7, signer, truststore)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ts
+	}
+	return res, nil
+}
+
+func verifySignerTimestamp(p7 *PKCS7, signer signerInfo, truststore *x509.CertPool) (*SignerTimestamp, error) {
+	for _, attr := range signer.UnauthenticatedAttributes {
+		switch {
+		case attr.Type.Equal(OIDAttributeRFC3161Timestamp):
+			return verifyTimestampToken(attr.Value.Bytes, signer.EncryptedDigest, truststore)
+		case attr.Type.Equal(OIDAttributeCountersignature):
+			return verifyCountersignature(p7, attr.Value.Bytes, signer.EncryptedDigest, truststore)
+		}
+	}
+	return nil, nil
+}
+
+// Verifies a RFC 3161 timestamp token over the encrypted digest of a signer
+func verifyTimestampToken(token []byte, encryptedDigest []byte, truststore *x509.CertPool) (*SignerTimestamp, error) {
+	info, err := ParseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := getHashForOID(info.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(encryptedDigest)
+	if !bytes.Equal(h.Sum(nil), info.HashedMessage) {
+		return nil, errors.New("pkcs7: timestamp token does not cover the signature")
+	}
+	tsa, err := verifyTimestampSigner(info.Token, truststore, info.Time)
+	if err != nil {
+		return nil, err
+	}
+	return &SignerTimestamp{Time: info.Time, TSA: tsa}, nil
+}
+
+// Verifies a legacy countersignature (signer info of the TSA) over the encrypted digest of a signer,
+// the certificates of the TSA are contained in the countersigned signed data
+func verifyCountersignature(p7 *PKCS7, value []byte, encryptedDigest []byte, truststore *x509.CertPool) (*SignerTimestamp, error) {
+	var counterSigner signerInfo
+	if _, err := asn1.Unmarshal(value, &counterSigner); err != nil {
+		return nil, fmt.Errorf("pkcs7: parsing countersignature failed: %w", err)
+	}
+	var signingTime time.Time
+	if err := unmarshalAttribute(counterSigner.AuthenticatedAttributes, OIDAttributeSigningTime, &signingTime); err != nil {
+		return nil, errors.New("pkcs7: countersignature has no signing time")
+	}
+	// The content of the countersignature is the encrypted digest, the message digest attribute must cover it
+	cs := &PKCS7{Content: encryptedDigest, Certificates: p7.Certificates, Signers: []signerInfo{counterSigner}}
+	tsa, err := verifyTimestampSigner(cs, truststore, signingTime)
+	if err != nil {
+		return nil, err
+	}
+	return &SignerTimestamp{Time: signingTime, Legacy: true, TSA: tsa}, nil
+}
+
+// Verifies the signature of a TSA and returns its certificate
+func verifyTimestampSigner(p7 *PKCS7, truststore *x509.CertPool, t time.Time) (*x509.Certificate, error) {
+	if err := p7.VerifyWithChainAtTime(truststore, t); err != nil {
+		return nil, fmt.Errorf("pkcs7: timestamp signature invalid: %w", err)
+	}
+	tsa := p7.GetOnlySigner()
+	if tsa == nil {
+		return nil, errors.New("pkcs7: timestamp must have exactly one signer")
+	}
+	if !slices.Contains(tsa.ExtKeyUsage, x509.ExtKeyUsageTimeStamping) {
+		return nil, fmt.Errorf("pkcs7: certificate %s is not valid for timestamping", tsa.Subject)
+	}
+	if t.Before(tsa.NotBefore) || t.After(tsa.NotAfter) {
+		return nil, fmt.Errorf("pkcs7: timestamp %s is outside of the validity of certificate %s", t.Format(time.RFC3339), tsa.Subject)
+	}
+	return tsa, nil
+}
+
+// Adds certificates that are not yet contained in the signed data
+func (sd *signedData) addCertificates(certs []*x509.Certificate) error {
+	existing, err := sd.Certificates.Parse()
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		if !slices.ContainsFunc(existing, cert.Equal) {
+			existing = append(existing, cert)
+		}
+	}
+	sd.Certificates = marshalCertificates(existing)
+	return nil
+}
+
+func getOIDForHash(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return OIDDigestAlgorithmSHA1, nil
+	case crypto.SHA256:
+		return OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("pkcs7: unsupported hash %v for timestamps", hash)
+}
@@ -0,0 +1,218 @@
+package pkcs7_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/internal/tsatest"
+	"github.com/coffeeforyou/vbasig/pkcs7"
+)
+
+// Signed data with one RSA signer over content
+func signedData(t *testing.T, content []byte) *pkcs7.PKCS7 {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vbasig test signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p7
+}
+
+func TestRequestToken(t *testing.T) {
+	at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	tsa := tsatest.New(t, tsatest.Config{Time: at})
+	data := []byte("encrypted digest")
+	token, err := (&pkcs7.TimestampClient{URL: tsa.URL}).RequestToken(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := pkcs7.ParseTimestampToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Time.Equal(at) {
+		t.Errorf("timestamp %s, want %s", info.Time, at)
+	}
+	if !info.HashAlgorithm.Equal(pkcs7.OIDDigestAlgorithmSHA256) {
+		t.Errorf("hash algorithm %s, want SHA256", info.HashAlgorithm)
+	}
+	if info.Nonce == nil {
+		t.Error("token without nonce")
+	}
+}
+
+func TestRequestTokenRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  tsatest.Config
+		err  string
+	}{
+		{"nonce mismatch", tsatest.Config{WrongNonce: true}, "nonce does not match the request"},
+		{"wrong message imprint", tsatest.Config{WrongImprint: true}, "token does not match the request"},
+		{"rejection", tsatest.Config{Status: 2}, "rejected with status 2"},
+		{"waiting", tsatest.Config{Status: 3}, "rejected with status 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tsa := tsatest.New(t, test.cfg)
+			_, err := (&pkcs7.TimestampClient{URL: tsa.URL}).RequestToken([]byte("encrypted digest"))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestAddTimestamps(t *testing.T) {
+	at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	tsa := tsatest.New(t, tsatest.Config{Time: at})
+	p7 := signedData(t, []byte("content"))
+	if err := p7.AddTimestamps(&pkcs7.TimestampClient{URL: tsa.URL}); err != nil {
+		t.Fatal(err)
+	}
+	der, err := p7.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err = pkcs7.Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature invalid after adding the timestamp: %v", err)
+	}
+	timestamps, err := p7.VerifyTimestamps(tsa.Roots())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 1 || timestamps[0] == nil {
+		t.Fatalf("timestamps %v, want one", timestamps)
+	}
+	ts := timestamps[0]
+	if !ts.Time.Equal(at) || ts.Legacy || !ts.TSA.Equal(tsa.Certificate) {
+		t.Errorf("timestamp %s (legacy %t) by %s, want %s by %s", ts.Time, ts.Legacy, ts.TSA.Subject, at, tsa.Certificate.Subject)
+	}
+
+	// A timestamp of another signature must not verify
+	other := signedData(t, []byte("content"))
+	if err := other.AddTimestamps(&pkcs7.TimestampClient{URL: tsa.URL}); err != nil {
+		t.Fatal(err)
+	}
+	p7.Signers[0].UnauthenticatedAttributes = other.Signers[0].UnauthenticatedAttributes
+	if _, err := p7.VerifyTimestamps(tsa.Roots()); err == nil || !strings.Contains(err.Error(), "does not cover the signature") {
+		t.Fatalf("got error %v for a foreign timestamp, want coverage error", err)
+	}
+}
+
+func TestVerifyTimestampsUntrustedTSA(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{})
+	p7 := signedData(t, []byte("content"))
+	if err := p7.AddTimestamps(&pkcs7.TimestampClient{URL: tsa.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p7.VerifyTimestamps(x509.NewCertPool()); err == nil {
+		t.Fatal("timestamp of an untrusted TSA verified")
+	}
+}
+
+func TestRequestCountersignature(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{})
+	data := []byte("encrypted digest")
+	resp, err := (&pkcs7.TimestampClient{URL: tsa.URL, Legacy: true}).RequestCountersignature(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Content, data) || len(resp.Signers) != 1 {
+		t.Fatalf("response over %q with %d signers, want %q with one", resp.Content, len(resp.Signers), data)
+	}
+
+	tsa = tsatest.New(t, tsatest.Config{WrongImprint: true})
+	_, err = (&pkcs7.TimestampClient{URL: tsa.URL, Legacy: true}).RequestCountersignature(data)
+	if err == nil || !strings.Contains(err.Error(), "does not match the request") {
+		t.Fatalf("got error %v for a countersignature over other content, want mismatch", err)
+	}
+}
+
+func TestAddCountersignature(t *testing.T) {
+	at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	tsa := tsatest.New(t, tsatest.Config{Time: at})
+	p7 := signedData(t, []byte("content"))
+	if err := p7.AddTimestamps(&pkcs7.TimestampClient{URL: tsa.URL, Legacy: true}); err != nil {
+		t.Fatal(err)
+	}
+	der, err := p7.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err = pkcs7.Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature invalid after adding the countersignature: %v", err)
+	}
+	attrs := p7.Signers[0].UnauthenticatedAttributes
+	if len(attrs) != 1 || !attrs[0].Type.Equal(pkcs7.OIDAttributeCountersignature) {
+		t.Fatalf("unauthenticated attributes %v, want one countersignature (1.2.840.113549.1.9.6)", attrs)
+	}
+	if !slices.ContainsFunc(p7.Certificates, tsa.Certificate.Equal) {
+		t.Error("certificate of the TSA not added to the signed data")
+	}
+	timestamps, err := p7.VerifyTimestamps(tsa.Roots())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timestamps) != 1 || timestamps[0] == nil {
+		t.Fatalf("timestamps %v, want one", timestamps)
+	}
+	ts := timestamps[0]
+	if !ts.Time.Equal(at) || !ts.Legacy || !ts.TSA.Equal(tsa.Certificate) {
+		t.Errorf("timestamp %s (legacy %t) by %s, want legacy %s by %s", ts.Time, ts.Legacy, ts.TSA.Subject, at, tsa.Certificate.Subject)
+	}
+
+	// The message digest of the countersignature covers the encrypted digest of the signer
+	p7.Signers[0].EncryptedDigest[0] ^= 0xff
+	if _, err := p7.VerifyTimestamps(tsa.Roots()); err == nil {
+		t.Fatal("countersignature over a changed signature verified")
+	}
+}
@@ -1,6 +1,9 @@
 package pkcs7
 
 import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -108,7 +111,7 @@ func verifySignatureAtTime(p7 *PKCS7, signer signerInfo, truststore *x509.CertPo
 	if err != nil {
 		return err
 	}
-	return ee.CheckSignature(sigalg, signedData, signer.EncryptedDigest)
+	return checkSignature(ee, sigalg, signedData, signer.EncryptedDigest)
 }
 
 func verifySignature(p7 *PKCS7, signer signerInfo, truststore *x509.CertPool) (err error) {
@@ -163,7 +166,22 @@ func verifySignature(p7 *PKCS7, signer signerInfo, truststore *x509.CertPool) (e
 	if err != nil {
 		return err
 	}
-	return ee.CheckSignature(sigalg, signedData, signer.EncryptedDigest)
+	return checkSignature(ee, sigalg, signedData, signer.EncryptedDigest)
+}
+
+// checkSignature verifies the signature of the end-entity certificate over signedData.
+// MD5 with RSA is rejected by x509.Certificate.CheckSignature as insecure, but is
+// still required for VBA signatures (V1), so it is verified directly.
+func checkSignature(ee *x509.Certificate, sigalg x509.SignatureAlgorithm, signedData, signature []byte) error {
+	if sigalg != x509.MD5WithRSA {
+		return ee.CheckSignature(sigalg, signedData, signature)
+	}
+	pub, ok := ee.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("pkcs7: MD5 with RSA signature but certificate has no RSA public key")
+	}
+	h := md5.Sum(signedData)
+	return rsa.VerifyPKCS1v15(pub, crypto.MD5, h[:], signature)
 }
 
 // GetOnlySigner returns an x509.Certificate for the first signer of the signed
@@ -277,6 +295,8 @@ func getSignatureAlgorithm(digestEncryption, digest pkix.AlgorithmIdentifier) (x
 		digestEncryption.Algorithm.Equal(OIDEncryptionAlgorithmRSASHA384),
 		digestEncryption.Algorithm.Equal(OIDEncryptionAlgorithmRSASHA512):
 		switch {
+		case digest.Algorithm.Equal(OIDDigestAlgorithmMD5):
+			return x509.MD5WithRSA, nil
 		case digest.Algorithm.Equal(OIDDigestAlgorithmSHA1):
 			return x509.SHA1WithRSA, nil
 		case digest.Algorithm.Equal(OIDDigestAlgorithmSHA256):
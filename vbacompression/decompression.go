@@ -6,18 +6,18 @@ import (
 )
 
 // The CompressedContainer is a SignatureByte followed by array of CompressedChunk structures.
-func DecompressContainer(compressedData []byte) ([]byte, uint16, error) {
+func DecompressContainer(compressedData []byte) ([]byte, int, error) {
 	// Keep track of position in compressed data
-	var pos uint16 = 0
+	pos := 0
 	// all decompressed data
 	decompressedBuffer := []uint8{}
 	// Check signature byte
-	if compressedData[pos] != 0x01 {
+	if len(compressedData) == 0 || compressedData[pos] != 0x01 {
 		return nil, 0, fmt.Errorf("invalid signature byte")
 	}
 	pos++
 	// Decompress chunks until no data left
-	for pos < uint16(len(compressedData)-1) {
+	for pos < len(compressedData)-1 {
 		decompressedBufferNew, newPos, err := decompressChunk(compressedData, pos, decompressedBuffer)
 		if err != nil {
 			return nil, 0, err
@@ -28,8 +28,11 @@ func DecompressContainer(compressedData []byte) ([]byte, uint16, error) {
 	return decompressedBuffer, pos, nil
 }
 
-func decompressChunk(compressedData []byte, pos uint16, decompressedBuffer []byte) ([]byte, uint16, error) {
+func decompressChunk(compressedData []byte, pos int, decompressedBuffer []byte) ([]byte, int, error) {
 	// Read the header, first 2 bytes
+	if pos+2 > len(compressedData) {
+		return nil, 0, fmt.Errorf("truncated chunk header at pos %d of %d", pos, len(compressedData))
+	}
 	headerBits := binary.LittleEndian.Uint16(compressedData[pos : pos+2])
 	pos += 2
 	// CompressedChunkSize is an unsigned integer that specifies the number of bytes in the CompressedChunk minus 3 (-1 and 2 header bytes). MUST be greater than or equal to zero.
@@ -45,9 +48,12 @@ func decompressChunk(compressedData []byte, pos uint16, decompressedBuffer []byt
 		return nil, 0, fmt.Errorf("invalid combination of chunk flag %d and chunk size %d at pos %d of %d", compressedChunkFlag, compressedChunkSize, pos, len(compressedData))
 	}
 	// last byte of chunk in compressed data
-	var chunkEndPos uint16 = pos + compressedChunkSize
+	chunkEndPos := pos + int(compressedChunkSize)
+	if chunkEndPos >= len(compressedData) {
+		return nil, 0, fmt.Errorf("truncated chunk at pos %d of %d, chunk size %d", pos, len(compressedData), compressedChunkSize)
+	}
 	// The location of the first byte of the DecompressedChunk within the DecompressedBuffer
-	var decompressedChunkStart = uint16(len(decompressedBuffer))
+	decompressedChunkStart := len(decompressedBuffer)
 
 	// Determine if uncompress chunk based on flag
 	if compressedChunkFlag == 1 {
@@ -66,7 +72,7 @@ func decompressChunk(compressedData []byte, pos uint16, decompressedBuffer []byt
 	return decompressedBuffer, pos, nil
 }
 
-func decompressTokenSequence(compressedData []byte, pos uint16, decompressedBuffer []byte, chunkEndPos uint16, decompressedChunkStart uint16) ([]byte, uint16, error) {
+func decompressTokenSequence(compressedData []byte, pos int, decompressedBuffer []byte, chunkEndPos int, decompressedChunkStart int) ([]byte, int, error) {
 	// Keeping track of errors
 	var err error
 	// FlagByte (1 byte): Each bit specifies the type of a Token in the TokenSequence. A value of 0b0 specifies a LiteralToken. A value of 0b1 specifies a CopyToken.
@@ -96,10 +102,13 @@ func decompressTokenSequence(compressedData []byte, pos uint16, decompressedBuff
 	return decompressedBuffer, pos, nil
 }
 
-func decodeCopyToken(compressedData []byte, pos uint16, decompressedBuffer []byte, decompressedChunkStart uint16) ([]byte, uint16, error) {
+func decodeCopyToken(compressedData []byte, pos int, decompressedBuffer []byte, decompressedChunkStart int) ([]byte, int, error) {
+	if pos+2 > len(compressedData) {
+		return nil, 0, fmt.Errorf("truncated copy token at pos %d of %d", pos, len(compressedData))
+	}
 	copyToken := binary.LittleEndian.Uint16(compressedData[pos : pos+2])
 	// number of bits in copyToken for length of copied sequence, function expects DecompressedCurrent minus DecompressedChunkStart
-	tokenLenBitCount, err := getCopyTokenOffsetBitCount(uint16(len(decompressedBuffer)) - decompressedChunkStart)
+	tokenLenBitCount, err := getCopyTokenOffsetBitCount(len(decompressedBuffer) - decompressedChunkStart)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -113,7 +122,10 @@ func decodeCopyToken(compressedData []byte, pos uint16, decompressedBuffer []byt
 	// tokenOff = DecompressedCurrent - copyStart - 1  -> copyStart = DecompressedCurrent - tokenOff - 1
 	tokenOff := uint16(copyToken >> (tokenLenBitCount))
 	// position in already decompressed data where copied byte sequence with tokenLen starts
-	copyStart := uint16(len(decompressedBuffer)) - tokenOff - 1
+	copyStart := len(decompressedBuffer) - int(tokenOff) - 1
+	if copyStart < decompressedChunkStart {
+		return nil, 0, fmt.Errorf("copy token at pos %d refers before start of chunk", pos)
+	}
 	for range tokenLen {
 		decompressedBuffer = append(decompressedBuffer, decompressedBuffer[copyStart])
 		copyStart++
@@ -121,7 +133,7 @@ func decodeCopyToken(compressedData []byte, pos uint16, decompressedBuffer []byt
 	return decompressedBuffer, pos + 2, nil
 }
 
-func getCopyTokenOffsetBitCount(decompressedBufferLength uint16) (uint16, error) {
+func getCopyTokenOffsetBitCount(decompressedBufferLength int) (uint16, error) {
 	if decompressedBufferLength <= 16 {
 		return 12, nil
 	}
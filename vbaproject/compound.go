@@ -0,0 +1,184 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+// Storages holding the VBA project in binary Office files: Excel (.xls, .xlt, .xla) and Word (.doc, .dot),
+// binary PowerPoint files embed it in a stream (see powerpoint.go)
+var vbaStorages = []string{"_VBA_PROJECT_CUR", "Macros"}
+
+// Name of the stream in the VBA project storage of binary Office files containing the signature
+func (v SignatureVersion) StreamName() string {
+	switch v {
+	case SignatureV1:
+		return "\x05DigitalSignature"
+	case SignatureAgile:
+		return "\x05DigitalSignatureEx"
+	case SignatureV3:
+		return "\x05DigitalSignatureExt"
+	}
+	return ""
+}
+
+// Reports whether r is a compound file (binary Office file or vbaProject.bin) instead of an Office Open XML package
+func isCompoundFile(r io.ReaderAt) bool {
+	header := make([]byte, 8)
+	_, err := r.ReadAt(header, 0)
+	return err == nil && cfb.IsCompoundFile(header)
+}
+
+// Signs the VBA project of a binary Office file, the signature streams are replaced and the compound file is rewritten
+func signCompoundDocument(w io.Writer, r io.ReaderAt, size int64, signCert *x509.Certificate, so SignOptions, sign signFunc) (*VbaProject, *SignReport, error) {
+	root, err := cfb.Read(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	storage, err := findVbaStorage(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	vbaProject, err := parseVbaStorage(storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Existing signatures to keep are checked before signing
+	parse := vbasigfile.ParseDigSigBlob
+	if isWordStorage(storage) {
+		parse = vbasigfile.ParseWordSigBlob
+	}
+	var kept []SignatureVersion
+	for _, version := range SignatureVersions {
+		stream := storage.Stream(version.StreamName())
+		if !so.keeps(version) || stream == nil {
+			continue
+		}
+		sv := verifyParsedSignature(vbaProject, version, stream.Data, parse, VerifyOptions{})
+		sv.PartName = signatureStreamPath(storage, version)
+		if err = checkKeptSignature(sv); err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, version)
+	}
+	signatures, err := sign(vbaProject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Signatures of versions not included are removed unless kept, like in Office Open XML packages
+	report := &SignReport{}
+	for _, version := range SignatureVersions {
+		if slices.Contains(kept, version) {
+			report.Kept = append(report.Kept, signatureStreamPath(storage, version))
+			continue
+		}
+		written := slices.ContainsFunc(signatures, func(sig projectSignature) bool { return sig.version == version })
+		report.add(signatureStreamPath(storage, version), storage.Stream(version.StreamName()) != nil, written)
+		storage.RemoveStream(version.StreamName())
+	}
+	for _, sig := range signatures {
+		sigInfo, err := vbasigfile.NewDigSigInfoSerialized(sig.signature, *signCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		blob := sigInfo.SerializeDigSigBlob()
+		if isWordStorage(storage) {
+			if blob, err = sigInfo.SerializeWordSigBlob(); err != nil {
+				return nil, nil, err
+			}
+		}
+		storage.SetStream(sig.version.StreamName(), blob)
+	}
+	if _, err = root.WriteTo(w); err != nil {
+		return nil, nil, err
+	}
+	return vbaProject, report, nil
+}
+
+// Printable path of the signature stream of the given version, e.g. _VBA_PROJECT_CUR/\x05DigitalSignatureExt
+func signatureStreamPath(storage *cfb.Storage, version SignatureVersion) string {
+	return fmt.Sprintf("%s/%s", storage.Name, strings.ReplaceAll(version.StreamName(), "\x05", `\x05`))
+}
+
+// Returns the storage of the VBA project in a binary Office file
+func findVbaStorage(root *cfb.Storage) (*cfb.Storage, error) {
+	for _, name := range vbaStorages {
+		if storage := root.Storage(name); storage != nil {
+			return storage, nil
+		}
+	}
+	if isVbaProjectStorage(root) {
+		return nil, fmt.Errorf("%w: standalone vbaProject.bin, the signatures are stored in separate parts (see SignVbaProjectParts)", ErrUnsupportedFormat)
+	}
+	if isPowerPointStorage(root) {
+		return nil, fmt.Errorf("%w: VBA projects of binary PowerPoint files are embedded in the %s stream and can only be read", ErrUnsupportedFormat, powerPointDocumentStream)
+	}
+	return nil, fmt.Errorf("%w: no %s storage", ErrNoVbaProject, strings.Join(vbaStorages, " or "))
+}
+
+// Returns the storage of the VBA project in a binary Office file for reading, including the project embedded in
+// binary PowerPoint files, and the parser of its signature streams
+func readVbaStorage(root *cfb.Storage) (*cfb.Storage, func([]byte) (*vbasigfile.DigSigInfoSerialized, error), error) {
+	if isPowerPointStorage(root) {
+		storage, err := readPowerPointVbaStorage(root)
+		return storage, parsePowerPointSigBlob, err
+	}
+	storage, err := findVbaStorage(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isWordStorage(storage) {
+		return storage, vbasigfile.ParseWordSigBlob, nil
+	}
+	return storage, vbasigfile.ParseDigSigBlob, nil
+}
+
+// Word stores the signatures as WordSigBlob, Excel as DigSigBlob
+func isWordStorage(storage *cfb.Storage) bool {
+	return strings.EqualFold(storage.Name, "Macros")
+}
+
+// Parses the VBA project of a storage, which has the same structure as vbaProject.bin
+func parseVbaStorage(storage *cfb.Storage) (*VbaProject, error) {
+	var buf bytes.Buffer
+	if _, err := storage.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return ParseVbaProject(bytes.NewReader(buf.Bytes()))
+}
+
+// Verifies the signature streams of the VBA project storage of a binary Office file
+func verifyCompoundDocument(r io.ReaderAt, size int64, vo VerifyOptions) ([]SignatureVerification, error) {
+	root, err := cfb.Read(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	storage, parse, err := readVbaStorage(root)
+	if err != nil {
+		return nil, err
+	}
+	// The project is parsed from the same storage as when signing, so that the same streams are hashed
+	vbaProject, err := parseVbaStorage(storage)
+	if err != nil {
+		return nil, err
+	}
+	var res []SignatureVerification
+	for _, version := range SignatureVersions {
+		stream := storage.Stream(version.StreamName())
+		if stream == nil {
+			continue
+		}
+		sv := verifyParsedSignature(vbaProject, version, stream.Data, parse, vo)
+		sv.PartName = signatureStreamPath(storage, version)
+		res = append(res, sv)
+	}
+	return res, nil
+}
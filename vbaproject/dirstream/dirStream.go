@@ -2,6 +2,8 @@ package dirstream
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 )
 
 // DIR STREAM Record
@@ -30,3 +32,12 @@ func ParseDirStream(reader *bytes.Reader) (*DirStream, error) {
 	}
 	return &ds, nil
 }
+
+// Checks a size read from the dir stream against the remaining data, so that corrupt sizes
+// do not cause huge allocations or misleading short reads later on
+func checkSize(reader io.Reader, size uint32) error {
+	if r, ok := reader.(*bytes.Reader); ok && int64(size) > int64(r.Len()) {
+		return fmt.Errorf("record size %d exceeds remaining %d", size, r.Len())
+	}
+	return nil
+}
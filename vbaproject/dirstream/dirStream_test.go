@@ -0,0 +1,20 @@
+package dirstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestRecordSizeExceedsData(t *testing.T) {
+	// PROJECTNAME record claiming more bytes than the stream holds
+	var b []byte
+	b = binary.LittleEndian.AppendUint16(b, 0x0004)
+	b = binary.LittleEndian.AppendUint32(b, 0x7fffffff)
+	b = append(b, "VBAProject"...)
+	_, err := ParseProjectInfo(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "record size 2147483647 exceeds remaining 10") {
+		t.Fatalf("got error %v, want record size error", err)
+	}
+}
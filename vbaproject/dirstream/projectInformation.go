@@ -129,10 +129,16 @@ func ParseProjectInfo(reader *bytes.Reader) (*ProjectInformation, error) {
 			err = binary.Read(reader, binary.LittleEndian, &pi.CodePage)
 		case 0x0004:
 			binary.Read(reader, binary.LittleEndian, &pi.Name.SizeOfProjectName)
+			if err := checkSize(reader, pi.Name.SizeOfProjectName); err != nil {
+				return nil, err
+			}
 			pi.Name.ProjectName = make([]uint8, pi.Name.SizeOfProjectName)
 			err = binary.Read(reader, binary.LittleEndian, &pi.Name.ProjectName)
 		case 0x0005:
 			binary.Read(reader, binary.LittleEndian, &pi.DocString.SizeOfDocString)
+			if err := checkSize(reader, pi.DocString.SizeOfDocString); err != nil {
+				return nil, err
+			}
 			pi.DocString.DocString = make([]uint8, pi.DocString.SizeOfDocString)
 			err = binary.Read(reader, binary.LittleEndian, &pi.DocString.DocString)
 			if err != nil {
@@ -140,11 +146,17 @@ func ParseProjectInfo(reader *bytes.Reader) (*ProjectInformation, error) {
 			}
 			binary.Read(reader, binary.LittleEndian, &pi.DocString.Reserved)
 			binary.Read(reader, binary.LittleEndian, &pi.DocString.SizeOfDocStringUnicode)
+			if err := checkSize(reader, pi.DocString.SizeOfDocStringUnicode); err != nil {
+				return nil, err
+			}
 			tmp16 := make([]uint16, pi.DocString.SizeOfDocStringUnicode/2)
 			err = binary.Read(reader, binary.LittleEndian, &tmp16)
 			pi.DocString.DocStringUnicode = string(utf16.Decode(tmp16))
 		case 0x0006:
 			binary.Read(reader, binary.LittleEndian, &pi.HelpFilePath.SizeOfHelpFile1)
+			if err := checkSize(reader, pi.HelpFilePath.SizeOfHelpFile1); err != nil {
+				return nil, err
+			}
 			pi.HelpFilePath.HelpFile1 = make([]uint8, pi.HelpFilePath.SizeOfHelpFile1)
 			err = binary.Read(reader, binary.LittleEndian, &pi.HelpFilePath.HelpFile1)
 			if err != nil {
@@ -152,6 +164,9 @@ func ParseProjectInfo(reader *bytes.Reader) (*ProjectInformation, error) {
 			}
 			binary.Read(reader, binary.LittleEndian, &pi.HelpFilePath.Reserved)
 			binary.Read(reader, binary.LittleEndian, &pi.HelpFilePath.SizeOfHelpFile2)
+			if err := checkSize(reader, pi.HelpFilePath.SizeOfHelpFile2); err != nil {
+				return nil, err
+			}
 			pi.HelpFilePath.HelpFile2 = make([]uint8, pi.HelpFilePath.SizeOfHelpFile2)
 			err = binary.Read(reader, binary.LittleEndian, &pi.HelpFilePath.HelpFile2)
 		case 0x0007:
@@ -162,6 +177,9 @@ func ParseProjectInfo(reader *bytes.Reader) (*ProjectInformation, error) {
 			err = binary.Read(reader, binary.LittleEndian, &pi.Version)
 		case 0x000c:
 			binary.Read(reader, binary.LittleEndian, &pi.Constants.SizeOfConstants)
+			if err := checkSize(reader, pi.Constants.SizeOfConstants); err != nil {
+				return nil, err
+			}
 			pi.Constants.Constants = make([]uint8, pi.Constants.SizeOfConstants)
 			err = binary.Read(reader, binary.LittleEndian, &pi.Constants.Constants)
 			if err != nil {
@@ -169,6 +187,9 @@ func ParseProjectInfo(reader *bytes.Reader) (*ProjectInformation, error) {
 			}
 			binary.Read(reader, binary.LittleEndian, &pi.Constants.Reserved)
 			binary.Read(reader, binary.LittleEndian, &pi.Constants.SizeOfConstantsUnicode)
+			if err := checkSize(reader, pi.Constants.SizeOfConstantsUnicode); err != nil {
+				return nil, err
+			}
 			pi.Constants.ConstantsUnicode = make([]uint8, pi.Constants.SizeOfConstantsUnicode)
 			err = binary.Read(reader, binary.LittleEndian, &pi.Constants.ConstantsUnicode)
 			if err != nil {
@@ -124,15 +124,24 @@ func ParseProjectModules(reader *bytes.Reader, pi *ProjectInformation) (*Project
 		case 0x0019: // MODULENAME Record
 			tmp = Module{}
 			binary.Read(reader, binary.LittleEndian, &tmp.NameRecord.SizeOfModuleName)
+			if err := checkSize(reader, tmp.NameRecord.SizeOfModuleName); err != nil {
+				return nil, err
+			}
 			tmp.NameRecord.ModuleName = make([]byte, tmp.NameRecord.SizeOfModuleName)
 			binary.Read(reader, binary.LittleEndian, &tmp.NameRecord.ModuleName)
 		case 0x0047: // MODULENAMEUNICODE Record
 			tmp.NameUnicodeRecord = &ModuleNameUnicodeRecord{}
 			binary.Read(reader, binary.LittleEndian, &tmp.NameUnicodeRecord.SizeOfModuleNameUnicode)
+			if err := checkSize(reader, tmp.NameUnicodeRecord.SizeOfModuleNameUnicode); err != nil {
+				return nil, err
+			}
 			tmp.NameUnicodeRecord.ModuleNameUnicode = make([]byte, tmp.NameUnicodeRecord.SizeOfModuleNameUnicode)
 			binary.Read(reader, binary.LittleEndian, &tmp.NameUnicodeRecord.ModuleNameUnicode)
 		case 0x001a: // MODULESTREAMNAME Record
 			binary.Read(reader, binary.LittleEndian, &tmp.StreamNameRecord.SizeOfStreamName)
+			if err := checkSize(reader, tmp.StreamNameRecord.SizeOfStreamName); err != nil {
+				return nil, err
+			}
 			tmp.StreamNameRecord.StreamName = make([]byte, tmp.StreamNameRecord.SizeOfStreamName)
 			binary.Read(reader, binary.LittleEndian, &tmp.StreamNameRecord.StreamName)
 			binary.Read(reader, binary.LittleEndian, &tmp.StreamNameRecord.Reserved)
@@ -140,14 +149,23 @@ func ParseProjectModules(reader *bytes.Reader, pi *ProjectInformation) (*Project
 				return nil, fmt.Errorf("reserved constant of MODULESTREAM record incorrect: %x", tmp.StreamNameRecord.Reserved)
 			}
 			binary.Read(reader, binary.LittleEndian, &tmp.StreamNameRecord.SizeOfStreamNameUnicode)
+			if err := checkSize(reader, tmp.StreamNameRecord.SizeOfStreamNameUnicode); err != nil {
+				return nil, err
+			}
 			tmp.StreamNameRecord.StreamNameUnicode = make([]byte, tmp.StreamNameRecord.SizeOfStreamNameUnicode)
 			binary.Read(reader, binary.LittleEndian, &tmp.StreamNameRecord.StreamNameUnicode)
 		case 0x001c: // MODULEDOCSTRING Record
 			binary.Read(reader, binary.LittleEndian, &tmp.DocStringRecord.SizeOfDocString)
+			if err := checkSize(reader, tmp.DocStringRecord.SizeOfDocString); err != nil {
+				return nil, err
+			}
 			tmp.DocStringRecord.DocString = make([]byte, tmp.DocStringRecord.SizeOfDocString)
 			binary.Read(reader, binary.LittleEndian, &tmp.DocStringRecord.DocString)
 			binary.Read(reader, binary.LittleEndian, &tmp.DocStringRecord.Reserved)
 			binary.Read(reader, binary.LittleEndian, &tmp.DocStringRecord.SizeOfDocStringUnicode)
+			if err := checkSize(reader, tmp.DocStringRecord.SizeOfDocStringUnicode); err != nil {
+				return nil, err
+			}
 			tmp.DocStringRecord.DocStringUnicode = make([]byte, tmp.DocStringRecord.SizeOfDocStringUnicode)
 			binary.Read(reader, binary.LittleEndian, &tmp.DocStringRecord.DocStringUnicode)
 		case 0x0031: // MODULEOFFSET Record
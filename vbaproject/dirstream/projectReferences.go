@@ -103,6 +103,9 @@ func ParseProjectReferences(reader *bytes.Reader, pi *ProjectInformation) (*Proj
 			tmp.RegisteredReference = &ReferenceRegistered{}
 			binary.Read(reader, binary.LittleEndian, &tmp.RegisteredReference.Size)
 			binary.Read(reader, binary.LittleEndian, &tmp.RegisteredReference.SizeOfLibid)
+			if err := checkSize(reader, tmp.RegisteredReference.SizeOfLibid); err != nil {
+				return nil, err
+			}
 			tmp.RegisteredReference.Libid = make([]byte, tmp.RegisteredReference.SizeOfLibid)
 			binary.Read(reader, binary.LittleEndian, &tmp.RegisteredReference.Libid)
 			binary.Read(reader, binary.LittleEndian, &tmp.RegisteredReference.Reserved1)
@@ -111,6 +114,9 @@ func ParseProjectReferences(reader *bytes.Reader, pi *ProjectInformation) (*Proj
 		case 0x0033: // REFERENCEORIGINAL
 			tmp.OriginalReference = &ReferenceOriginal{}
 			binary.Read(reader, binary.LittleEndian, &tmp.OriginalReference.SizeOfLibidOriginal)
+			if err := checkSize(reader, tmp.OriginalReference.SizeOfLibidOriginal); err != nil {
+				return nil, err
+			}
 			tmp.OriginalReference.LibidOriginal = make([]byte, tmp.OriginalReference.SizeOfLibidOriginal)
 			binary.Read(reader, binary.LittleEndian, &tmp.OriginalReference.LibidOriginal)
 			reader.Read(make([]byte, 2)) // ignore two bytes for control record id, must be 0x002f
@@ -120,9 +126,15 @@ func ParseProjectReferences(reader *bytes.Reader, pi *ProjectInformation) (*Proj
 			tmp.ProjectReference = &ReferenceProject{}
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.Size)
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.SizeOfLibidAbsolute)
+			if err := checkSize(reader, tmp.ProjectReference.SizeOfLibidAbsolute); err != nil {
+				return nil, err
+			}
 			tmp.ProjectReference.LibidAbsolute = make([]byte, tmp.ProjectReference.SizeOfLibidAbsolute)
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.LibidAbsolute)
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.SizeOfLibidRelative)
+			if err := checkSize(reader, tmp.ProjectReference.SizeOfLibidRelative); err != nil {
+				return nil, err
+			}
 			tmp.ProjectReference.LibidRelative = make([]byte, tmp.ProjectReference.SizeOfLibidRelative)
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.LibidRelative)
 			binary.Read(reader, binary.LittleEndian, &tmp.ProjectReference.MajorVersion)
@@ -143,10 +155,16 @@ func ParseProjectReferences(reader *bytes.Reader, pi *ProjectInformation) (*Proj
 func parseNameRecord(reader io.Reader) (*ReferenceName, error) {
 	tmp := ReferenceName{}
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeOfName)
+	if err := checkSize(reader, tmp.SizeOfName); err != nil {
+		return nil, err
+	}
 	tmp.Name = make([]byte, tmp.SizeOfName)
 	binary.Read(reader, binary.LittleEndian, &tmp.Name)
 	binary.Read(reader, binary.LittleEndian, &tmp.Reserved)
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeOfNameUnicode)
+	if err := checkSize(reader, tmp.SizeOfNameUnicode); err != nil {
+		return nil, err
+	}
 	tmp.NameUnicode = make([]byte, tmp.SizeOfNameUnicode)
 	binary.Read(reader, binary.LittleEndian, &tmp.NameUnicode)
 	return &tmp, nil
@@ -157,6 +175,9 @@ func parseControlRecord(reader io.Reader) (*ReferenceControl, error) {
 	var err error
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeTwiddled)
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeOfLibidTwiddled)
+	if err := checkSize(reader, tmp.SizeOfLibidTwiddled); err != nil {
+		return nil, err
+	}
 	tmp.LibidTwiddled = make([]byte, tmp.SizeOfLibidTwiddled)
 	binary.Read(reader, binary.LittleEndian, &tmp.LibidTwiddled)
 	binary.Read(reader, binary.LittleEndian, &tmp.Reserved1)
@@ -174,6 +195,9 @@ func parseControlRecord(reader io.Reader) (*ReferenceControl, error) {
 	}
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeExtended)
 	binary.Read(reader, binary.LittleEndian, &tmp.SizeOfLibidExtended)
+	if err := checkSize(reader, tmp.SizeOfLibidExtended); err != nil {
+		return nil, err
+	}
 	tmp.LibidExtended = make([]byte, tmp.SizeOfLibidExtended)
 	binary.Read(reader, binary.LittleEndian, &tmp.LibidExtended)
 	binary.Read(reader, binary.LittleEndian, &tmp.Reserved4)
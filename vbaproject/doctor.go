@@ -0,0 +1,403 @@
+package vbaproject
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+const relationshipsContentType = "application/vnd.openxmlformats-package.relationships+xml"
+
+// Content types of the main document part of the macro-enabled formats by file extension, with the content type
+// and extension of the macro-free variant (if any)
+var macroEnabledFormats = map[string]struct{ contentType, plainContentType, plainExt string }{
+	"xlsm": {"application/vnd.ms-excel.sheet.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml", "xlsx"},
+	"xltm": {"application/vnd.ms-excel.template.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml", "xltx"},
+	"xlam": {"application/vnd.ms-excel.addin.macroEnabled.main+xml", "", ""},
+	"xlsb": {"application/vnd.ms-excel.sheet.binary.macroEnabled.main", "", ""},
+	"docm": {"application/vnd.ms-word.document.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml", "docx"},
+	"dotm": {"application/vnd.ms-word.template.macroEnabledTemplate.main+xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml", "dotx"},
+	"pptm": {"application/vnd.ms-powerpoint.presentation.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml", "pptx"},
+	"potm": {"application/vnd.ms-powerpoint.template.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.presentationml.template.main+xml", "potx"},
+	"ppsm": {"application/vnd.ms-powerpoint.slideshow.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.presentationml.slideshow.main+xml", "ppsx"},
+	"ppam": {"application/vnd.ms-powerpoint.addin.macroEnabled.main+xml", "", ""},
+	"vsdm": {"application/vnd.ms-visio.drawing.macroEnabled.main+xml", "application/vnd.ms-visio.drawing.main+xml", "vsdx"},
+	"vstm": {"application/vnd.ms-visio.template.macroEnabled.main+xml", "application/vnd.ms-visio.template.main+xml", "vstx"},
+	"vssm": {"application/vnd.ms-visio.stencil.macroEnabled.main+xml", "application/vnd.ms-visio.stencil.main+xml", "vssx"},
+}
+
+// Problem in the package structure around the VBA project that makes Office ignore the macros or their signatures
+type Problem struct {
+	Part        string // part the problem was found in, e.g. [Content_Types].xml
+	Description string
+	Fixable     bool // can be fixed by RepairDocument
+	Fixed       bool // was fixed by RepairDocument
+
+	fix func(pkg *opc.Package) error
+}
+
+func (p Problem) String() string {
+	res := fmt.Sprintf("%s: %s", p.Part, p.Description)
+	switch {
+	case p.Fixed:
+		res += " (fixed)"
+	case !p.Fixable:
+		res += " (cannot be fixed automatically)"
+	}
+	return res
+}
+
+// Checks the package structure around the VBA project of an Office file, see CheckDocument
+func CheckVbaProject(officeFilePath string) ([]Problem, error) {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer officeFile.Close()
+	return CheckDocument(officeFile, size, filepath.Ext(officeFilePath))
+}
+
+// Checks the package structure around the VBA project of an Office file read from r (size bytes): the relationship
+// from the main document part to the VBA project, the relationships to the signature parts, the content types of
+// the VBA project and signature parts and the content type of the main document part against the file type
+// (extension, e.g. ".xlsm", the check is skipped if empty). Returns no problems if the structure is sound.
+// Binary Office files (.xls, .doc) have no package structure and are reported as ErrUnsupportedFormat.
+func CheckDocument(r io.ReaderAt, size int64, fileType string) ([]Problem, error) {
+	if isCompoundFile(r) {
+		return nil, fmt.Errorf("%w: binary Office files have no package structure to check", ErrUnsupportedFormat)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return checkPackage(pkg, fileType)
+}
+
+// Repairs the package structure around the VBA project of an Office file and writes the repaired file as
+// <name>-repaired.<ext> next to it. The file is only written if a problem could be fixed.
+func RepairVbaProject(officeFilePath string) ([]Problem, error) {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer officeFile.Close()
+
+	problems, err := CheckDocument(officeFile, size, filepath.Ext(officeFilePath))
+	if err != nil || !hasFixableProblem(problems) {
+		return problems, err
+	}
+	_, err = createFileNextTo(officeFilePath, "repaired", func(w io.Writer) error {
+		problems, err = RepairDocument(w, officeFile, size, filepath.Ext(officeFilePath))
+		return err
+	})
+	return problems, err
+}
+
+// Fixes the problems found by CheckDocument that can be fixed and writes the file to w. Missing relationships and
+// content types are added and dangling ones removed by editing the parts in place, all other parts are copied
+// byte-identical. Returns all problems found, those fixed are marked as Fixed.
+func RepairDocument(w io.Writer, r io.ReaderAt, size int64, fileType string) ([]Problem, error) {
+	if isCompoundFile(r) {
+		return nil, fmt.Errorf("%w: binary Office files have no package structure to repair", ErrUnsupportedFormat)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := checkPackage(pkg, fileType)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range problems {
+		if !p.Fixable {
+			continue
+		}
+		if err := p.fix(pkg); err != nil {
+			return nil, fmt.Errorf("cannot fix %s: %w", p, err)
+		}
+		problems[i].Fixed = true
+	}
+	return problems, pkg.Write(w, time.Time{})
+}
+
+func hasFixableProblem(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Fixable {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPackage(pkg *opc.Package, fileType string) ([]Problem, error) {
+	types, err := pkg.ContentTypes()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	mainPart := findMainDocumentPart(pkg)
+	if mainPart == nil {
+		problems = append(problems, Problem{Part: opc.RelationshipsPartName(""), Description: "no relationship to the main document part"})
+	}
+	vbaPart, err := findVbaProjectPart(pkg)
+	if err != nil {
+		// A VBA project without relationship and with a wrong content type is only found by its name
+		p := findPartByBaseName(pkg, "vbaProject.bin")
+		if p == nil {
+			return nil, err
+		}
+		vbaPart = p.Name
+	}
+
+	if mainPart != nil {
+		if pkg.RelatedPart(mainPart.Name, vbaProjectRelType) == nil {
+			problems = append(problems, Problem{
+				Part:        opc.RelationshipsPartName(mainPart.Name),
+				Description: fmt.Sprintf("no vbaProject relationship from %s to %s, Office ignores the macros", mainPart.Name, vbaPart),
+				Fixable:     true,
+				fix: func(pkg *opc.Package) error {
+					return addRelationship(pkg, mainPart.Name, vbaProjectRelType, vbaPart)
+				},
+			})
+		}
+		problems = append(problems, checkMainContentType(mainPart.Name, types.ContentType(mainPart.Name), fileType)...)
+	}
+	if p, ok := checkContentType(types, vbaPart, vbaProjectContentType); !ok {
+		problems = append(problems, p)
+	}
+
+	relationships, err := pkg.Relationships(vbaPart)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	relsPart := opc.RelationshipsPartName(vbaPart)
+	needsRels := relationships != nil
+	for _, version := range SignatureVersions {
+		name := findSignaturePart(pkg, vbaPart, version)
+		if p := pkg.Part(name); p != nil {
+			needsRels = true
+			if pkg.RelatedPart(vbaPart, version.RelationshipType()) == nil {
+				problems = append(problems, Problem{
+					Part:        relsPart,
+					Description: fmt.Sprintf("no %s relationship to %s, Office ignores the signature", version, p.Name),
+					Fixable:     true,
+					fix: func(pkg *opc.Package) error {
+						return addRelationship(pkg, vbaPart, version.RelationshipType(), p.Name)
+					},
+				})
+			}
+			if problem, ok := checkContentType(types, p.Name, version.ContentType()); !ok {
+				problems = append(problems, problem)
+			}
+			continue
+		}
+
+		// Relationships and overrides of missing signature parts make Office report the file as corrupt
+		if relationships != nil && hasDanglingRelationship(pkg, vbaPart, relationships, version.RelationshipType()) {
+			problems = append(problems, Problem{
+				Part:        relsPart,
+				Description: fmt.Sprintf("%s relationship to missing part %s", version, name),
+				Fixable:     true,
+				fix: func(pkg *opc.Package) error {
+					return removeDanglingRelationships(pkg, vbaPart, version.RelationshipType())
+				},
+			})
+		}
+		if hasOverride(types, name) {
+			problems = append(problems, Problem{
+				Part:        opc.ContentTypesName,
+				Description: fmt.Sprintf("override for missing part /%s", name),
+				Fixable:     true,
+				fix: func(pkg *opc.Package) error {
+					return editContentTypes(pkg, func(part *xmlPart) { removeOverrides(part, name) })
+				},
+			})
+		}
+	}
+	if needsRels {
+		if p, ok := checkContentType(types, relsPart, relationshipsContentType); !ok {
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// Checks the content type of the main document part against the file type, missing macro support makes Office
+// ignore the VBA project
+func checkMainContentType(mainPart, contentType, fileType string) []Problem {
+	ext := strings.ToLower(strings.TrimPrefix(fileType, "."))
+	if format, ok := macroEnabledFormats[ext]; ok {
+		if strings.EqualFold(contentType, format.contentType) {
+			return nil
+		}
+		if format.plainContentType != "" && strings.EqualFold(contentType, format.plainContentType) {
+			return []Problem{{
+				Part:        opc.ContentTypesName,
+				Description: fmt.Sprintf("main part /%s has content type %s, which is not macro-enabled, expected %s", mainPart, contentType, format.contentType),
+				Fixable:     true,
+				fix: func(pkg *opc.Package) error {
+					return setContentType(pkg, mainPart, format.contentType)
+				},
+			}}
+		}
+		return []Problem{{
+			Part:        opc.ContentTypesName,
+			Description: fmt.Sprintf("main part /%s has content type %q, which does not match the file extension .%s (expected %s)", mainPart, contentType, ext, format.contentType),
+		}}
+	}
+	for macroExt, format := range macroEnabledFormats {
+		if format.plainExt != "" && format.plainExt == ext {
+			return []Problem{{
+				Part:        mainPart,
+				Description: fmt.Sprintf(".%s files cannot contain macros, Office ignores the VBA project: rename the file to .%s", ext, macroExt),
+			}}
+		}
+	}
+	return nil
+}
+
+// Checks the content type of a part, a wrong or missing content type is fixed with an override
+func checkContentType(types *opc.Types, partName, contentType string) (Problem, bool) {
+	actual := types.ContentType(partName)
+	if strings.EqualFold(actual, contentType) {
+		return Problem{}, true
+	}
+	description := fmt.Sprintf("no content type for /%s, expected %s", partName, contentType)
+	if actual != "" {
+		description = fmt.Sprintf("/%s has content type %s, expected %s", partName, actual, contentType)
+	}
+	return Problem{
+		Part:        opc.ContentTypesName,
+		Description: description,
+		Fixable:     true,
+		fix: func(pkg *opc.Package) error {
+			return setContentType(pkg, partName, contentType)
+		},
+	}, false
+}
+
+func hasOverride(types *opc.Types, partName string) bool {
+	for _, o := range types.Overrides {
+		if strings.EqualFold(o.PartName, "/"+partName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the source part has an internal relationship of the given type whose target is missing
+func hasDanglingRelationship(pkg *opc.Package, source string, relationships *opc.Relationships, relType string) bool {
+	for _, r := range relationships.Relationships {
+		if r.Type == relType && !r.IsExternal() && pkg.Part(opc.ResolveTarget(source, r.Target)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first part with the given file name (compared case-insensitive) in any folder
+func findPartByBaseName(pkg *opc.Package, name string) *opc.Part {
+	for _, p := range pkg.Parts() {
+		if strings.EqualFold(path.Base(p.Name), name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Adds a relationship of the given type from the source part to the target part, with a target relative to the
+// folder of the source part if both are in the same folder
+func addRelationship(pkg *opc.Package, source, relType, target string) error {
+	relTarget := relativeTarget(source, target)
+	return editRelationships(pkg, source, func(part *xmlPart) {
+		ids := map[string]bool{}
+		for _, r := range part.elements("Relationship") {
+			ids[r.attrs["Id"]] = true
+		}
+		part.insert("Relationship", [2]string{"Id", newRelationshipID(ids)}, [2]string{"Type", relType}, [2]string{"Target", relTarget})
+	})
+}
+
+// Removes the internal relationships of the given type of the source part whose target is missing
+func removeDanglingRelationships(pkg *opc.Package, source, relType string) error {
+	return editRelationships(pkg, source, func(part *xmlPart) {
+		for _, r := range part.elements("Relationship") {
+			if r.attrs["Type"] == relType && !strings.EqualFold(r.attrs["TargetMode"], "External") &&
+				pkg.Part(opc.ResolveTarget(source, r.attrs["Target"])) == nil {
+				part.remove(r)
+			}
+		}
+	})
+}
+
+// Edits the relationships part of the source part in place. The relationships part is created if missing
+// and removed if no relationship is left.
+func editRelationships(pkg *opc.Package, source string, edit func(part *xmlPart)) error {
+	name := opc.RelationshipsPartName(source)
+	data := []byte(DefaultRels)
+	if p := pkg.Part(name); p != nil {
+		name = p.Name
+		var err error
+		if data, err = p.Data(); err != nil {
+			return err
+		}
+	}
+	part, err := parseXMLPart(data, "Relationships")
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	edit(part)
+	data = part.bytes()
+	if rest, err := parseXMLPart(data, "Relationships"); err == nil && len(rest.elements("Relationship")) == 0 {
+		pkg.RemovePart(name)
+		return nil
+	}
+	pkg.SetPart(name, data)
+	return nil
+}
+
+// Sets the content type of a part with an override, replacing existing overrides of the part
+func setContentType(pkg *opc.Package, partName, contentType string) error {
+	return editContentTypes(pkg, func(part *xmlPart) {
+		removeOverrides(part, partName)
+		part.insert("Override", [2]string{"PartName", "/" + partName}, [2]string{"ContentType", contentType})
+	})
+}
+
+func removeOverrides(part *xmlPart, partName string) {
+	for _, o := range part.elements("Override") {
+		if strings.EqualFold(o.attrs["PartName"], "/"+partName) {
+			part.remove(o)
+		}
+	}
+}
+
+// Edits [Content_Types].xml in place
+func editContentTypes(pkg *opc.Package, edit func(part *xmlPart)) error {
+	p := pkg.Part(opc.ContentTypesName)
+	if p == nil {
+		return fmt.Errorf("%s: %w", opc.ContentTypesName, fs.ErrNotExist)
+	}
+	data, err := p.Data()
+	if err != nil {
+		return err
+	}
+	part, err := parseXMLPart(data, "Types")
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+	edit(part)
+	pkg.SetPart(p.Name, part.bytes())
+	return nil
+}
@@ -0,0 +1,147 @@
+package vbaproject
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+func TestRepairDocument(t *testing.T) {
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), so); err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := CheckDocument(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm"); err != nil || len(problems) != 0 {
+		t.Fatalf("got %v, %v for the signed document, want no problems", problems, err)
+	}
+
+	tests := []struct {
+		name     string
+		fileType string
+		edit     func(t *testing.T, pkg *opc.Package)
+		want     []string // descriptions of the problems found
+		fixable  bool
+	}{
+		{
+			name:     "relationships of the VBA project missing",
+			fileType: ".xlsm",
+			edit: func(t *testing.T, pkg *opc.Package) {
+				pkg.RemovePart("xl/_rels/vbaProject.bin.rels")
+			},
+			want: []string{
+				"no V1 relationship to xl/vbaProjectSignature.bin",
+				"no Agile relationship to xl/vbaProjectSignatureAgile.bin",
+				"no V3 relationship to xl/vbaProjectSignatureV3.bin",
+			},
+			fixable: true,
+		},
+		{
+			name:     "override missing",
+			fileType: ".xlsm",
+			edit: func(t *testing.T, pkg *opc.Package) {
+				replacePart(t, pkg, opc.ContentTypesName, `<Override PartName="/xl/vbaProjectSignatureV3.bin" ContentType="application/vnd.ms-office.vbaProjectSignatureV3"/>`, "")
+			},
+			want:    []string{"/xl/vbaProjectSignatureV3.bin has content type application/vnd.ms-office.vbaProject, expected application/vnd.ms-office.vbaProjectSignatureV3"},
+			fixable: true,
+		},
+		{
+			name:     "main content type not macro-enabled",
+			fileType: ".xlsm",
+			edit: func(t *testing.T, pkg *opc.Package) {
+				replacePart(t, pkg, opc.ContentTypesName, "application/vnd.ms-excel.sheet.macroEnabled.main+xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml")
+			},
+			want:    []string{"main part /xl/workbook.xml has content type application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml, which is not macro-enabled"},
+			fixable: true,
+		},
+		{
+			name:     "macro-free extension",
+			fileType: ".xlsx",
+			want:     []string{".xlsx files cannot contain macros"},
+		},
+		{
+			name:     "extension of another application",
+			fileType: ".docm",
+			want:     []string{"does not match the file extension .docm"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := signed.Bytes()
+			if test.edit != nil {
+				pkg, err := opc.Open(bytes.NewReader(input), int64(len(input)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				test.edit(t, pkg)
+				var edited bytes.Buffer
+				if err := pkg.Write(&edited, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+				input = edited.Bytes()
+			}
+
+			problems, err := CheckDocument(bytes.NewReader(input), int64(len(input)), test.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkProblems(t, problems, test.want, test.fixable, false)
+
+			var repaired bytes.Buffer
+			problems, err = RepairDocument(&repaired, bytes.NewReader(input), int64(len(input)), test.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkProblems(t, problems, test.want, test.fixable, test.fixable)
+
+			problems, err = CheckDocument(bytes.NewReader(repaired.Bytes()), int64(repaired.Len()), test.fileType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.fixable {
+				checkProblems(t, problems, nil, false, false)
+				results, err := VerifyDocument(bytes.NewReader(repaired.Bytes()), int64(repaired.Len()), test.fileType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range results {
+					if !r.Valid {
+						t.Errorf("after repair: %v", r)
+					}
+				}
+			} else {
+				checkProblems(t, problems, test.want, false, false)
+			}
+		})
+	}
+}
+
+// Checks that the problems match the wanted descriptions in order
+func checkProblems(t *testing.T, problems []Problem, want []string, fixable, fixed bool) {
+	t.Helper()
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %v, want %q", problems, want)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.Description, want[i]) || p.Fixable != fixable || p.Fixed != fixed {
+			t.Errorf("got problem %v (fixable %v, fixed %v), want %q (fixable %v, fixed %v)", p, p.Fixable, p.Fixed, want[i], fixable, fixed)
+		}
+	}
+}
+
+// Replaces the first occurrence of old in a part
+func replacePart(t *testing.T, pkg *opc.Package, name, old, new string) {
+	t.Helper()
+	data, err := pkg.ReadPart(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(old)) {
+		t.Fatalf("%s does not contain %s", name, old)
+	}
+	pkg.SetPart(name, bytes.Replace(data, []byte(old), []byte(new), 1))
+}
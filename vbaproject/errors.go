@@ -0,0 +1,14 @@
+package vbaproject
+
+import "errors"
+
+// Errors returned when signing, parsing or verifying VBA projects, wrapped with details
+var (
+	ErrNoVbaProject      = errors.New("no VBA project found")
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+	ErrCorruptVbaProject = errors.New("corrupt VBA project")
+	ErrCorruptDirStream  = errors.New("corrupt dir stream")
+	ErrModuleNotFound    = errors.New("module not found")
+	ErrNoSignature       = errors.New("no VBA signature found")
+	ErrDocumentChanged   = errors.New("document changed since the signing request was prepared")
+)
@@ -0,0 +1,106 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/coffeeforyou/vbasig/util"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Key material used to sign a VBA project
+type Identity struct {
+	Certificate *x509.Certificate   // signing certificate
+	Signer      crypto.Signer       // private key of the signing certificate (RSA or ECDSA), may be held outside the process
+	CACerts     []*x509.Certificate // (optional) issuing certificates added to the signature
+}
+
+// Creates an identity from a signer and its certificate chain, starting with the signing certificate
+func NewIdentity(signer crypto.Signer, chain []*x509.Certificate) (*Identity, error) {
+	if signer == nil || len(chain) == 0 || chain[0] == nil {
+		return nil, errors.New("identity requires a signer and a signing certificate")
+	}
+	pub, ok := chain[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return nil, fmt.Errorf("public key of signer does not match certificate %s", chain[0].Subject)
+	}
+	// Issuing certificates may be given in any order, unrelated ones are kept at the end and rejected when signing
+	caCerts, unrelated := orderChain(chain[0], chain[1:])
+	return &Identity{Certificate: chain[0], Signer: signer, CACerts: append(caCerts, unrelated...)}, nil
+}
+
+// Loads the signing certificate, private key and issuing certificates from a password protected PKCS#12 file (.pfx, .p12)
+func LoadIdentityPKCS12(path string, password string) (*Identity, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIdentityPKCS12(b, password)
+}
+
+// Decodes a PKCS#12 file (.pfx, .p12) containing the signing certificate, private key and issuing certificates
+func ParseIdentityPKCS12(data []byte, password string) (*Identity, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("decoding PKCS#12 failed: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	// PKCS#12 files may contain further certificates (e.g. other roots), only the chain of the signing certificate is used
+	caCerts, _ = orderChain(cert, caCerts)
+	return NewIdentity(signer, append([]*x509.Certificate{cert}, caCerts...))
+}
+
+// Loads the signing certificate and private key (PEM) and optionally an issuing certificate (PEM)
+func LoadIdentity(certPath string, keyPath string, caPath string) (*Identity, error) {
+	signCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading key pair failed: %w", err)
+	}
+	signer, ok := signCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", signCert.PrivateKey)
+	}
+	chain := []*x509.Certificate{signCert.Leaf}
+	if caPath != "" {
+		caCert, err := util.LoadPemCertificate(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading issuing certificate failed: %w", err)
+		}
+		chain = append(chain, caCert)
+	}
+	return NewIdentity(signer, chain)
+}
+
+// Orders the issuing certificates from the issuer of the signing certificate upwards, as required by AddSignerChain.
+// Returns the ordered chain and the certificates that are not part of it.
+func orderChain(cert *x509.Certificate, caCerts []*x509.Certificate) ([]*x509.Certificate, []*x509.Certificate) {
+	var chain []*x509.Certificate
+	remaining := slices.DeleteFunc(slices.Clone(caCerts), func(c *x509.Certificate) bool { return c == nil })
+	for {
+		idx := slices.IndexFunc(remaining, func(c *x509.Certificate) bool {
+			return !c.Equal(cert) && bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil
+		})
+		if idx < 0 {
+			return chain, remaining
+		}
+		cert = remaining[idx]
+		chain = append(chain, cert)
+		remaining = slices.Delete(remaining, idx, idx+1)
+	}
+}
+
+func (id *Identity) validate() error {
+	if id == nil || id.Certificate == nil || id.Signer == nil {
+		return errors.New("identity requires a certificate and a signer")
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestLoadIdentityPKCS12(t *testing.T) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	// Certificate for the key, issued by the parent certificate and key (self-signed if nil)
+	newCert := func(serial int64, commonName string, isCA bool, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: commonName},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+		}
+		if isCA {
+			template.KeyUsage = x509.KeyUsageCertSign
+		} else {
+			template.KeyUsage = x509.KeyUsageDigitalSignature
+			template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	rootKey, intermediateKey, key, otherKey := newKey(), newKey(), newKey(), newKey()
+	root := newCert(1, "vbasig test root", true, rootKey, nil, nil)
+	intermediate := newCert(2, "vbasig test intermediate", true, intermediateKey, root, rootKey)
+	leaf := newCert(3, "vbasig test signer", false, key, intermediate, intermediateKey)
+	other := newCert(4, "unrelated root", true, otherKey, nil, nil)
+
+	// Issuing certificates in the wrong order, with an unrelated certificate first
+	data, err := pkcs12.Modern.Encode(key, leaf, []*x509.Certificate{other, root, intermediate}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signer.p12")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	id, err := LoadIdentityPKCS12(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Certificate.Equal(leaf) {
+		t.Errorf("got certificate %s, want %s", id.Certificate.Subject, leaf.Subject)
+	}
+	if !key.PublicKey.Equal(id.Signer.Public()) {
+		t.Error("signer does not match the key")
+	}
+	if len(id.CACerts) != 2 || !id.CACerts[0].Equal(intermediate) || !id.CACerts[1].Equal(root) {
+		var subjects []string
+		for _, c := range id.CACerts {
+			subjects = append(subjects, c.Subject.CommonName)
+		}
+		t.Errorf("got issuing certificates %q, want the intermediate and the root", subjects)
+	}
+
+	// The identity signs with the chain
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, SignOptions{IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	results, err := VerifyDocumentWithOptions(bytes.NewReader(signed.Bytes()), int64(signed.Len()), "", VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Valid {
+		t.Errorf("got %v, want a valid signature", results)
+	}
+
+	if _, err := ParseIdentityPKCS12(data, "wrong"); err == nil {
+		t.Error("wrong password accepted")
+	}
+}
+
+// Signer whose key is not accessible, like a key held in a HSM
+type opaqueSigner struct {
+	key   crypto.Signer
+	calls int
+}
+
+func (s *opaqueSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *opaqueSigner) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return s.key.Sign(r, digest, opts)
+}
+
+func TestNewIdentity(t *testing.T) {
+	cert := testIdentity(t).Certificate
+	signer := &opaqueSigner{key: testIdentityKey}
+	id, err := NewIdentity(signer, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, SignOptions{IncludeV1: true, IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+	if signer.calls != 2 {
+		t.Errorf("signer called %d times, want once per signature", signer.calls)
+	}
+	results, err := VerifyDocument(bytes.NewReader(signed.Bytes()), int64(signed.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].Valid || !results[1].Valid {
+		t.Errorf("got %v, want valid V1 and V3 signatures", results)
+	}
+
+	// The certificate must belong to the key of the signer
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIdentity(otherKey, []*x509.Certificate{cert}); err == nil {
+		t.Error("certificate of another key accepted")
+	}
+	if _, err := NewIdentity(signer, nil); err == nil {
+		t.Error("identity without certificate accepted")
+	}
+}
@@ -0,0 +1,313 @@
+package vbaproject
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/coffeeforyou/vbasig/vbaproject/dirstream"
+)
+
+// Hashes of the individual sections of a VBA project (modules, references, designer storages and project properties),
+// recorded at signing time to locate changes when a signature no longer matches
+type Manifest struct {
+	Version    int              `json:"version"`
+	Project    string           `json:"project"`
+	Properties []string         `json:"properties"` // lines of the PROJECT stream that are part of the signature
+	References []ManifestEntry  `json:"references"`
+	Modules    []ManifestModule `json:"modules"`
+}
+
+type ManifestEntry struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"` // hex encoded SHA-256
+}
+
+type ManifestModule struct {
+	Name     string          `json:"name"`
+	Lines    []string        `json:"lines"`              // hex encoded SHA-256 of each source line
+	Designer []ManifestEntry `json:"designer,omitempty"` // streams of the designer storage (forms)
+}
+
+// Change of a section of the VBA project compared to the manifest
+type SectionChange struct {
+	Section      string // module, reference, designer or property
+	Name         string
+	Change       string // added, removed or modified
+	RemovedLines []int  // line numbers (1-based) of the module at signing time that were removed or changed
+	AddedLines   []int  // line numbers (1-based) of the current module that were added or changed
+}
+
+func (c SectionChange) String() string {
+	res := fmt.Sprintf("%s %q %s", c.Section, c.Name, c.Change)
+	if len(c.RemovedLines) > 0 {
+		res += fmt.Sprintf(", signed lines %s", formatLineRanges(c.RemovedLines))
+	}
+	if len(c.AddedLines) > 0 {
+		res += fmt.Sprintf(", current lines %s", formatLineRanges(c.AddedLines))
+	}
+	return res
+}
+
+// Records the hashes of all sections of the VBA project
+func NewManifest(p *VbaProject) *Manifest {
+	m := Manifest{Version: 1, Project: p.ProjectStream.Name}
+	m.Properties = projectProperties(p)
+	for _, ref := range p.DirStream.ReferencesRecord.ReferenceArray {
+		m.References = append(m.References, ManifestEntry{Name: referenceName(ref), Hash: hashHex(referenceNormalizedData(ref))})
+	}
+	for _, ms := range p.ModuleStream.Modules {
+		mm := ManifestModule{Name: ms.Name}
+		for _, line := range parseModule(ms.SourceCode) {
+			mm.Lines = append(mm.Lines, hashHex(line))
+		}
+		if slices.Contains(p.ProjectStream.ProjectDesignerModules, ms.Name) {
+			for _, cs := range ms.ChildStreams {
+				mm.Designer = append(mm.Designer, ManifestEntry{Name: strings.Join(append(slices.Clone(cs.Path), cs.Name), "/"), Hash: hashHex(cs.Raw)})
+			}
+		}
+		m.Modules = append(m.Modules, mm)
+	}
+	return &m
+}
+
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest failed: %w", err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) Save(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Lists the sections of the VBA project that differ from the manifest
+func (m *Manifest) Compare(p *VbaProject) []SectionChange {
+	current := NewManifest(p)
+	var changes []SectionChange
+
+	// Project properties
+	for _, prop := range m.Properties {
+		if !slices.Contains(current.Properties, prop) {
+			changes = append(changes, SectionChange{Section: "property", Name: prop, Change: "removed"})
+		}
+	}
+	for _, prop := range current.Properties {
+		if !slices.Contains(m.Properties, prop) {
+			changes = append(changes, SectionChange{Section: "property", Name: prop, Change: "added"})
+		}
+	}
+
+	// References
+	changes = append(changes, compareEntries("reference", m.References, current.References)...)
+
+	// Modules and designer storages
+	for _, mm := range m.Modules {
+		idx := slices.IndexFunc(current.Modules, func(cm ManifestModule) bool { return cm.Name == mm.Name })
+		if idx < 0 {
+			changes = append(changes, SectionChange{Section: "module", Name: mm.Name, Change: "removed"})
+			continue
+		}
+		cm := current.Modules[idx]
+		removed, added := diffLines(mm.Lines, cm.Lines)
+		if len(removed) > 0 || len(added) > 0 {
+			changes = append(changes, SectionChange{Section: "module", Name: mm.Name, Change: "modified", RemovedLines: removed, AddedLines: added})
+		}
+		changes = append(changes, compareEntries("designer", mm.Designer, cm.Designer)...)
+	}
+	for _, cm := range current.Modules {
+		if !slices.ContainsFunc(m.Modules, func(mm ManifestModule) bool { return mm.Name == cm.Name }) {
+			changes = append(changes, SectionChange{Section: "module", Name: cm.Name, Change: "added"})
+		}
+	}
+	return changes
+}
+
+// Compares the VBA project of an Office file to a manifest recorded at signing time
+func CompareWithManifest(officeFilePath string, manifestPath string) ([]SectionChange, error) {
+	m, err := LoadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := LoadVbaProject(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return m.Compare(p), nil
+}
+
+// Name of the manifest written next to a signed file
+func ManifestPath(signedFilePath string) string {
+	return signedFilePath + ".manifest.json"
+}
+
+func compareEntries(section string, signed []ManifestEntry, current []ManifestEntry) []SectionChange {
+	var changes []SectionChange
+	for _, s := range signed {
+		idx := slices.IndexFunc(current, func(c ManifestEntry) bool { return c.Name == s.Name })
+		if idx < 0 {
+			changes = append(changes, SectionChange{Section: section, Name: s.Name, Change: "removed"})
+		} else if current[idx].Hash != s.Hash {
+			changes = append(changes, SectionChange{Section: section, Name: s.Name, Change: "modified"})
+		}
+	}
+	for _, c := range current {
+		if !slices.ContainsFunc(signed, func(s ManifestEntry) bool { return s.Name == c.Name }) {
+			changes = append(changes, SectionChange{Section: section, Name: c.Name, Change: "added"})
+		}
+	}
+	return changes
+}
+
+// Properties of the PROJECT stream that are included in the signature (see Project Normalized Data)
+func projectProperties(p *VbaProject) []string {
+	var res []string
+	for _, prop := range p.ProjectStream.MainProperties {
+		if !slices.Contains([]string{"ID", "Document", "CMG", "DPB", "GC"}, prop.Key) && !strings.HasPrefix(prop.Key, "&H") {
+			res = append(res, prop.Line)
+		}
+	}
+	for _, prop := range p.ProjectStream.HostExtenderProperties {
+		res = append(res, prop.Line)
+	}
+	return res
+}
+
+func referenceName(ref dirstream.Reference) string {
+	if ref.NameRecord != nil {
+		return string(ref.NameRecord.Name)
+	}
+	return ""
+}
+
+// Serialization of a reference as used for the V3 signature
+func referenceNormalizedData(ref dirstream.Reference) []byte {
+	buf := []byte{}
+	if ref.ControlReference != nil {
+		buf = writeControlReference(buf, ref.ControlReference)
+	} else if ref.OriginalReference != nil {
+		buf = writeOriginalReference(buf, ref.OriginalReference)
+	} else if ref.RegisteredReference != nil {
+		buf = writeRegisteredReference(buf, ref.RegisteredReference)
+	} else if ref.ProjectReference != nil {
+		buf = writeProjectReference(buf, ref.ProjectReference)
+	}
+	return buf
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Returns the line numbers (1-based) removed from a and added in b, based on the longest common subsequence.
+// The subsequence is found with Hirschberg's algorithm, in linear space.
+func diffLines(a []string, b []string) ([]int, []int) {
+	// Skip common prefix and suffix
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	var removed, added []int
+	diffRange(a[start:endA], b[start:endB], start+1, start+1, &removed, &added)
+	return removed, added
+}
+
+// Appends the line numbers of a removed and of b added, lineA and lineB are the numbers of their first lines
+func diffRange(a []string, b []string, lineA int, lineB int, removed *[]int, added *[]int) {
+	switch {
+	case len(a) == 0:
+		for j := range b {
+			*added = append(*added, lineB+j)
+		}
+		return
+	case len(b) == 0:
+		for i := range a {
+			*removed = append(*removed, lineA+i)
+		}
+		return
+	case len(a) == 1:
+		k := slices.Index(b, a[0])
+		if k < 0 {
+			*removed = append(*removed, lineA)
+		}
+		for j := range b {
+			if j != k {
+				*added = append(*added, lineB+j)
+			}
+		}
+		return
+	}
+	// Split b where the common subsequences of both halves of a add up to the longest
+	mid := len(a) / 2
+	forward := lcsLengths(a[:mid], b, false)
+	backward := lcsLengths(a[mid:], b, true)
+	split := 0
+	for k := range forward {
+		if forward[k]+backward[len(b)-k] > forward[split]+backward[len(b)-split] {
+			split = k
+		}
+	}
+	diffRange(a[:mid], b[:split], lineA, lineB, removed, added)
+	diffRange(a[mid:], b[split:], lineA+mid, lineB+split, removed, added)
+}
+
+// Returns the lengths of the longest common subsequences of a and the first k lines of b for each k,
+// of the last k lines if reverse is set
+func lcsLengths(a []string, b []string, reverse bool) []int {
+	at := func(s []string, i int) string {
+		if reverse {
+			return s[len(s)-1-i]
+		}
+		return s[i]
+	}
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if at(a, i) == at(b, j) {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// Formats line numbers as ranges, e.g. "3, 5-7"
+func formatLineRanges(lines []int) string {
+	var parts []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", lines[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
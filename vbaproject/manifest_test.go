@@ -0,0 +1,90 @@
+package vbaproject
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b           string
+		removed, added []int
+	}{
+		{"a b c", "a b c", nil, nil},
+		{"a b c", "a x c", []int{2}, []int{2}},
+		{"a b c", "a c", []int{2}, nil},
+		{"a c", "a b c", nil, []int{2}},
+		{"", "a b", nil, []int{1, 2}},
+		{"a b", "", []int{1, 2}, nil},
+		{"a b c d e f", "b c x e f y", []int{1, 4}, []int{3, 6}},
+		{"x a b c", "a b c x", []int{1}, []int{4}},
+	}
+	for _, test := range tests {
+		removed, added := diffLines(strings.Fields(test.a), strings.Fields(test.b))
+		if !slices.Equal(removed, test.removed) || !slices.Equal(added, test.added) {
+			t.Errorf("diffLines(%q, %q) = %v, %v, want %v, %v", test.a, test.b, removed, added, test.removed, test.added)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// Every third line changed, the common lines must all be kept
+	var a, b []string
+	for i := range 3000 {
+		a = append(a, fmt.Sprintf("line %d", i))
+		if i%3 == 0 {
+			b = append(b, fmt.Sprintf("changed %d", i))
+		} else {
+			b = append(b, a[i])
+		}
+	}
+	removed, added := diffLines(a, b)
+	if len(removed) != 1000 || len(added) != 1000 {
+		t.Fatalf("%d lines removed and %d added, want 1000 each", len(removed), len(added))
+	}
+	for i, line := range removed {
+		if line != 3*i+1 || added[i] != line {
+			t.Fatalf("changed line %d reported as removed %d and added %d", 3*i+1, line, added[i])
+		}
+	}
+}
+
+func TestManifestCompare(t *testing.T) {
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), SignOptions{IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadVbaProject(bytes.NewReader(signed.Bytes()), int64(signed.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest is compared as saved at signing time
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := NewManifest(p).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := m.Compare(p); len(changes) != 0 {
+		t.Errorf("got changes %v for the signed project", changes)
+	}
+
+	tampered := tamperModule(t, signed.Bytes())
+	p, err = ReadVbaProject(bytes.NewReader(tampered), int64(len(tampered)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := m.Compare(p)
+	want := SectionChange{Section: "module", Name: "Module1", Change: "modified", RemovedLines: []int{2}, AddedLines: []int{2}}
+	if len(changes) != 1 || changes[0].Section != want.Section || changes[0].Name != want.Name || changes[0].Change != want.Change ||
+		!slices.Equal(changes[0].RemovedLines, want.RemovedLines) || !slices.Equal(changes[0].AddedLines, want.AddedLines) {
+		t.Errorf("got changes %v, want %v", changes, want)
+	}
+}
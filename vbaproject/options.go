@@ -1,7 +1,81 @@
 package vbaproject
 
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
 type SignOptions struct {
-	IncludeV1    bool
-	IncludeAgile bool
-	IncludeV3    bool
+	IncludeV1       bool
+	IncludeAgile    bool
+	IncludeV3       bool
+	WriteManifest   bool   // write a manifest with hashes of the VBA project next to the signed file
+	TimestampURL    string // (optional) URL of a RFC 3161 time stamping authority, each signature gets a timestamp token
+	TimestampLegacy bool   // request legacy Authenticode countersignatures from TimestampURL instead of RFC 3161 tokens (older Office versions)
+	VbaFolder       string // (optional) standalone vbaProject.bin: folder of vbaProject.bin in the package for the content types, default "xl"
+	KeepSignatures  bool   // keep existing signatures of versions not included (e.g. a V1 signature of a vendor), they must still verify
+	SkipIfSigned    bool   // do not rewrite a document whose signatures of the included versions are valid and made with the same certificate
+
+	// Byte-identical output for the same input, key and options (e.g. to compare checksums of builds): the parts written get
+	// the SigningTime as modification time. Requires an RSA key (PKCS#1 v1.5 signatures are deterministic) and no
+	// TimestampURL, timestamps differ with each request.
+	Deterministic bool
+	// (optional) signing time, added to the signatures as signed attribute (PKCS#9 signingTime) and used as modification
+	// time of the parts written. It must be within the validity of the signing certificate. Without it the signatures
+	// have no signing time, like those of Office, and the parts written get 1980-01-01 in deterministic mode.
+	SigningTime time.Time
+}
+
+func (so SignOptions) vbaFolder() string {
+	if so.VbaFolder == "" {
+		return "xl"
+	}
+	return so.VbaFolder
+}
+
+// Signature versions included, in the order of SignatureVersions
+func (so SignOptions) versions() []SignatureVersion {
+	var res []SignatureVersion
+	for _, version := range SignatureVersions {
+		if version == SignatureV1 && so.IncludeV1 || version == SignatureAgile && so.IncludeAgile || version == SignatureV3 && so.IncludeV3 {
+			res = append(res, version)
+		}
+	}
+	return res
+}
+
+// Reports whether an existing signature of the version is kept instead of removed
+func (so SignOptions) keeps(version SignatureVersion) bool {
+	return so.KeepSignatures && !slices.Contains(so.versions(), version)
+}
+
+// Modification time of the zip entries written, zero keeps the time of replaced entries
+func (so SignOptions) partTime() time.Time {
+	if so.SigningTime.IsZero() && so.Deterministic {
+		return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return so.SigningTime
+}
+
+// Checks that signing with the identity gives the same output each time, if requested
+func (so SignOptions) checkDeterministic(id *Identity) error {
+	if !so.Deterministic {
+		return nil
+	}
+	if so.TimestampURL != "" {
+		return errors.New("deterministic signing does not allow timestamps")
+	}
+	if _, ok := id.Signer.Public().(*rsa.PublicKey); !ok {
+		return fmt.Errorf("deterministic signing requires an RSA key, signatures with %T keys are randomized", id.Signer.Public())
+	}
+	return nil
+}
+
+type VerifyOptions struct {
+	Roots       *x509.CertPool // (optional) trusted roots, the certificate chains of signers and time stamping authorities are only verified if set
+	CurrentTime time.Time      // (optional) time to check the signing certificate of signatures without a timestamp by a trusted TSA against, default now
 }
@@ -0,0 +1,137 @@
+package vbaproject
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+// Relationship types of the main document part, pointed to by the package relationships (_rels/.rels)
+var mainDocumentRelTypes = []string{
+	"http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument",
+	"http://purl.oclc.org/ooxml/officeDocument/relationships/officeDocument", // strict
+	"http://schemas.microsoft.com/visio/2010/relationships/document",
+}
+
+const (
+	vbaProjectRelType     = "http://schemas.microsoft.com/office/2006/relationships/vbaProject"
+	vbaProjectContentType = "application/vnd.ms-office.vbaProject"
+)
+
+// Extensions of compound files: binary Office files and vbaProject.bin
+var compoundFileTypes = []string{"xls", "xlt", "xla", "doc", "dot", "ppt", "pps", "pot", "bin"}
+
+// Checks the file type passed by the caller (extension, e.g. ".xlsm" or "xls") against the format detected from the
+// content, a compound file or an Office Open XML package. The format is only detected if the file type is empty.
+func checkFileType(r io.ReaderAt, fileType string) error {
+	ext := strings.ToLower(strings.TrimPrefix(fileType, "."))
+	if ext == "" {
+		return nil
+	}
+	wantCompound := slices.Contains(compoundFileTypes, ext)
+	if !wantCompound && !isOfficeOpenXMLType(ext) {
+		return fmt.Errorf("%w: unknown file type .%s", ErrUnsupportedFormat, ext)
+	}
+	if compound := isCompoundFile(r); compound != wantCompound {
+		format := "an Office Open XML package"
+		if compound {
+			format = "a compound file (binary Office file or vbaProject.bin)"
+		}
+		return fmt.Errorf("%w: the content of the .%s file is %s", ErrUnsupportedFormat, ext, format)
+	}
+	return nil
+}
+
+// Reports whether the extension is one of an Office Open XML format that can hold a VBA project, or its variant
+// without macros (e.g. a renamed .xlsx, reported by CheckDocument)
+func isOfficeOpenXMLType(ext string) bool {
+	for macroExt, format := range macroEnabledFormats {
+		if ext == macroExt || ext == format.plainExt {
+			return true
+		}
+	}
+	return false
+}
+
+// Opens an Office Open XML package, packages that are no zip archive are reported as ErrUnsupportedFormat
+func openPackage(r io.ReaderAt, size int64) (*opc.Package, error) {
+	pkg, err := opc.Open(r, size)
+	if errors.Is(err, opc.ErrFormat) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	return pkg, err
+}
+
+// Returns the name of the VBA project part (e.g. xl/vbaProject.bin) in the package.
+// The part is located through the relationships of the main document part, which works for all macro-enabled
+// formats (.xlsm, .xlsb, .xlam, .xltm, .docm, .dotm, .pptm, .potm, .ppsm, .ppam, .vsdm). If the relationships
+// are missing or broken, the part is located by its content type in [Content_Types].xml.
+func findVbaProjectPart(pkg *opc.Package) (string, error) {
+	if mainPart := findMainDocumentPart(pkg); mainPart != nil {
+		if p := pkg.RelatedPart(mainPart.Name, vbaProjectRelType); p != nil {
+			return p.Name, nil
+		}
+	}
+	if parts, err := pkg.PartsByContentType(vbaProjectContentType); err == nil && len(parts) > 0 {
+		return parts[0].Name, nil
+	}
+	return "", fmt.Errorf("%w: no vbaProject relationship or part with content type %s", ErrNoVbaProject, vbaProjectContentType)
+}
+
+// Returns the main document part targeted by the package relationships, nil if not present
+func findMainDocumentPart(pkg *opc.Package) *opc.Part {
+	for _, relType := range mainDocumentRelTypes {
+		if p := pkg.RelatedPart("", relType); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// Conventional name of the part of a signature, next to the VBA project part
+func signaturePartName(vbaPart string, version SignatureVersion) string {
+	return path.Join(path.Dir(vbaPart), version.FileName())
+}
+
+// Conventional names of the signature parts of all versions in the folder of the VBA project part
+func signaturePartNames(folder string) map[SignatureVersion]string {
+	res := map[SignatureVersion]string{}
+	for _, version := range SignatureVersions {
+		res[version] = path.Join(folder, version.FileName())
+	}
+	return res
+}
+
+// Name of the signature part of the given version: the target of its relationship from the VBA project part if that
+// part exists, the conventional name next to the VBA project part otherwise. The part may not exist.
+func findSignaturePart(pkg *opc.Package, vbaPart string, version SignatureVersion) string {
+	if p := pkg.RelatedPart(vbaPart, version.RelationshipType()); p != nil {
+		return p.Name
+	}
+	return signaturePartName(vbaPart, version)
+}
+
+// Names of the signature parts of all versions, see findSignaturePart
+func findSignatureParts(pkg *opc.Package, vbaPart string) map[SignatureVersion]string {
+	res := map[SignatureVersion]string{}
+	for _, version := range SignatureVersions {
+		res[version] = findSignaturePart(pkg, vbaPart, version)
+	}
+	return res
+}
+
+// Reads the signature part of the given version (see findSignaturePart), returns its actual name and fs.ErrNotExist if not present
+func readSignaturePart(pkg *opc.Package, vbaPart string, version SignatureVersion) (string, []byte, error) {
+	p := pkg.Part(findSignaturePart(pkg, vbaPart, version))
+	if p == nil {
+		return "", nil, fs.ErrNotExist
+	}
+	data, err := p.Data()
+	return p.Name, data, err
+}
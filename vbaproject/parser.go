@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"slices"
 	"strings"
 
@@ -15,11 +14,24 @@ import (
 	"github.com/richardlehane/mscfb"
 )
 
+// Parses a VBA project from vbaProject.bin or from a binary Office file (.xls, .doc), where the
+// VBA project is a storage of the compound file (e.g. _VBA_PROJECT_CUR or Macros), or a binary
+// PowerPoint file (.ppt), where it is embedded in the PowerPoint Document stream
 func ParseVbaProject(file io.ReaderAt) (*VbaProject, error) {
 	// Create new reader for OLE file system
 	doc, err := mscfb.New(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	projectPath, ok := findProjectPath(doc.File)
+	if !ok {
+		if embedded, err := readPowerPointVbaProject(doc.File); embedded != nil || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			return ParseVbaProject(bytes.NewReader(embedded))
+		}
+		return nil, fmt.Errorf("%w: dir stream missing", ErrNoVbaProject)
 	}
 	// Initialize VBA project
 	vbap := VbaProject{}
@@ -27,27 +39,34 @@ func ParseVbaProject(file io.ReaderAt) (*VbaProject, error) {
 	// First iteration over streams to read the relevant information (name, offset) to extract the VBA modules
 	// and create map with streams for convenient access
 	for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
+		path, ok := relativePath(entry.Path, projectPath)
+		if !ok {
+			continue
+		}
 		if entry.Size > 0 {
-			fullName := fmt.Sprintf("%s/%s", strings.Join(entry.Path, "/"), entry.Name)
+			fullName := fmt.Sprintf("%s/%s", strings.Join(path, "/"), entry.Name)
 			if _, ok := streams[fullName]; ok {
-				panic("duplicate stream name")
+				return nil, fmt.Errorf("%w: duplicate stream %s", ErrCorruptVbaProject, fullName)
 			}
 			streams[fullName] = entry
 		}
-		if entry.Name == "dir" {
-			compressedContainerBytes, _ := io.ReadAll(entry)
-			db, _, err := vbacompression.DecompressContainer(compressedContainerBytes)
+		if entry.Name == "dir" && len(path) == 1 && path[0] == "VBA" {
+			compressedContainerBytes, err := io.ReadAll(entry)
 			if err != nil {
 				return nil, err
 			}
+			db, _, err := vbacompression.DecompressContainer(compressedContainerBytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrCorruptDirStream, err)
+			}
 			vbaProjectReader := bytes.NewReader(db)
 			vbap.DirStream, err = dirstream.ParseDirStream(vbaProjectReader)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w: %v", ErrCorruptDirStream, err)
 			}
 		}
 
-		if entry.Name == "PROJECT" {
+		if entry.Name == "PROJECT" && len(path) == 0 {
 			b, err := io.ReadAll(entry)
 			if err != nil {
 				return nil, err
@@ -56,40 +75,107 @@ func ParseVbaProject(file io.ReaderAt) (*VbaProject, error) {
 		}
 	}
 
+	if vbap.DirStream == nil {
+		return nil, fmt.Errorf("%w: dir stream missing", ErrNoVbaProject)
+	}
+
 	// Iteration over modules to read all VBA modules
 	mswo := vbap.GetModulesWithOffset()
 	for _, mwo := range mswo {
-		if entry, ok := streams[fmt.Sprintf("VBA/%s", mwo.Name)]; ok {
-			moduleStreamBytes, err := io.ReadAll(entry)
-			if err != nil {
-				return nil, err
-			}
-			moduleStreamBytesEff := moduleStreamBytes[mwo.Offset:]
-			tmp, err := modulestream.ParseModuleStream(moduleStreamBytesEff)
-			tmp.Name = mwo.Name
-			tmp.Raw = moduleStreamBytes
-			if err != nil {
-				return nil, err
-			}
-			vbap.ModuleStream.Modules = append(vbap.ModuleStream.Modules, tmp)
+		entry, ok := streams[fmt.Sprintf("VBA/%s", mwo.Name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: stream VBA/%s missing", ErrModuleNotFound, mwo.Name)
+		}
+		moduleStreamBytes, err := io.ReadAll(entry)
+		if err != nil {
+			return nil, err
+		}
+		if int(mwo.Offset) > len(moduleStreamBytes) {
+			return nil, fmt.Errorf("%w: offset %d of module %s beyond stream size %d", ErrCorruptVbaProject, mwo.Offset, mwo.Name, len(moduleStreamBytes))
 		}
+		moduleStreamBytesEff := moduleStreamBytes[mwo.Offset:]
+		tmp, err := modulestream.ParseModuleStream(moduleStreamBytesEff)
+		if err != nil {
+			return nil, fmt.Errorf("%w: module %s: %v", ErrCorruptVbaProject, mwo.Name, err)
+		}
+		tmp.Name = mwo.Name
+		tmp.Raw = moduleStreamBytes
+		vbap.ModuleStream.Modules = append(vbap.ModuleStream.Modules, tmp)
 	}
 
 	// Third iteration of streams to add VBFrame information
-	doc, _ = mscfb.New(file)
+	doc, err = mscfb.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
 	for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
-		if entry.Size == 0 {
+		path, ok := relativePath(entry.Path, projectPath)
+		if entry.Size == 0 || !ok {
 			continue
 		}
 		for _, m := range vbap.ModuleStream.Modules {
-			if slices.Contains(entry.Path, m.Name) {
+			if slices.Contains(path, m.Name) {
 				csb, err := io.ReadAll(entry)
 				if err != nil {
 					return nil, err
 				}
-				m.ChildStreams = append(m.ChildStreams, modulestream.ChildStream{Raw: csb, Name: entry.Name, Path: entry.Path})
+				m.ChildStreams = append(m.ChildStreams, modulestream.ChildStream{Raw: csb, Name: entry.Name, Path: path})
 			}
 		}
 	}
 	return &vbap, nil
 }
+
+// Returns the compound file of the VBA project embedded in a binary PowerPoint file, nil if the entries are not
+// those of a binary PowerPoint file
+func readPowerPointVbaProject(entries []*mscfb.File) ([]byte, error) {
+	streams := make(map[string][]byte)
+	for _, entry := range entries {
+		if len(entry.Path) == 0 && (entry.Name == powerPointDocumentStream || entry.Name == powerPointCurrentUser) {
+			data := make([]byte, entry.Size)
+			if _, err := entry.ReadAt(data, 0); err != nil && err != io.EOF {
+				return nil, err
+			}
+			streams[entry.Name] = data
+		}
+	}
+	document, ok := streams[powerPointDocumentStream]
+	if !ok {
+		return nil, nil
+	}
+	currentUser, ok := streams[powerPointCurrentUser]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s stream missing", ErrCorruptVbaProject, powerPointCurrentUser)
+	}
+	return extractPowerPointVbaProject(currentUser, document)
+}
+
+// Returns the path of the storage containing the VBA project (VBA/dir and PROJECT): the root of vbaProject.bin
+// or one of the VBA storages of binary Office files
+func findProjectPath(entries []*mscfb.File) ([]string, bool) {
+	var candidates [][]string
+	for _, entry := range entries {
+		if entry.Name == "dir" && len(entry.Path) > 0 && entry.Path[len(entry.Path)-1] == "VBA" {
+			candidates = append(candidates, entry.Path[:len(entry.Path)-1])
+		}
+	}
+	for _, preferred := range append([]string{""}, vbaStorages...) {
+		for _, c := range candidates {
+			if strings.Join(c, "/") == preferred {
+				return c, true
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], true
+	}
+	return nil, false
+}
+
+// Returns the path relative to the project storage, false if the path is outside of it
+func relativePath(path []string, projectPath []string) ([]string, bool) {
+	if len(path) < len(projectPath) || !slices.Equal(path[:len(projectPath)], projectPath) {
+		return nil, false
+	}
+	return path[len(projectPath):], true
+}
@@ -0,0 +1,236 @@
+package vbaproject
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+// Binary PowerPoint files (.ppt, .pps, .pot) do not store the VBA project as storage of the compound file. It is a
+// compound file of its own (with the layout of vbaProject.bin), stored zlib compressed in an ExOleObjStg record of
+// the PowerPoint Document stream (MS-PPT 2.10.1). The record is located through the persist directory of the last
+// edit: DocumentContainer, DocInfoListContainer, VBAInfoContainer, VBAInfoAtom, persist object of the project.
+// Changing the project would require rewriting the stream with its persist directory, it can only be read.
+
+const (
+	powerPointDocumentStream = "PowerPoint Document"
+	powerPointCurrentUser    = "Current User"
+
+	// Record types (MS-PPT 2.13.24)
+	rtDocument             = 0x03E8
+	rtDocInfoList          = 0x07D0
+	rtVbaInfo              = 0x03FF
+	rtVbaInfoAtom          = 0x0400
+	rtUserEditAtom         = 0x0FF5
+	rtCurrentUserAtom      = 0x0FF6
+	rtExOleObjStg          = 0x1011
+	rtPersistDirectoryAtom = 0x1772
+
+	// headerToken of the CurrentUserAtom for encrypted files
+	encryptedDocumentToken = 0xF3D1C4DF
+)
+
+// Reports whether the compound file is a binary PowerPoint file
+func isPowerPointStorage(root *cfb.Storage) bool {
+	return root.Stream(powerPointDocumentStream) != nil
+}
+
+// Returns the storage of the VBA project embedded in a binary PowerPoint file, named after the stream containing it
+func readPowerPointVbaStorage(root *cfb.Storage) (*cfb.Storage, error) {
+	currentUser := root.Stream(powerPointCurrentUser)
+	if currentUser == nil {
+		return nil, fmt.Errorf("%w: %s stream missing", ErrCorruptVbaProject, powerPointCurrentUser)
+	}
+	data, err := extractPowerPointVbaProject(currentUser.Data, root.Stream(powerPointDocumentStream).Data)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := cfb.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: VBA project storage: %v", ErrCorruptVbaProject, powerPointDocumentStream, err)
+	}
+	storage.Name = powerPointDocumentStream
+	return storage, nil
+}
+
+// PowerPoint does not document the structure of the signature streams, Excel (DigSigBlob) and Word (WordSigBlob)
+// are told apart by the position of serializedPointer
+func parsePowerPointSigBlob(data []byte) (*vbasigfile.DigSigInfoSerialized, error) {
+	if len(data) >= 10 && binary.LittleEndian.Uint32(data[4:]) != 8 && binary.LittleEndian.Uint32(data[6:]) == 8 {
+		return vbasigfile.ParseWordSigBlob(data)
+	}
+	return vbasigfile.ParseDigSigBlob(data)
+}
+
+// Returns the compound file of the VBA project stored in the PowerPoint Document stream.
+// currentUser is the content of the Current User stream, which points to the last edit of the document.
+func extractPowerPointVbaProject(currentUser []byte, document []byte) ([]byte, error) {
+	rh, body, err := readPowerPointRecord(currentUser, 0)
+	if err != nil {
+		return nil, err
+	}
+	// size, headerToken, offsetToCurrentEdit
+	if rh.recType != rtCurrentUserAtom || len(body) < 12 {
+		return nil, fmt.Errorf("%w: %s: no CurrentUserAtom", ErrCorruptVbaProject, powerPointCurrentUser)
+	}
+	if binary.LittleEndian.Uint32(body[4:]) == encryptedDocumentToken {
+		return nil, fmt.Errorf("%w: encrypted PowerPoint file", ErrUnsupportedFormat)
+	}
+
+	// Persist object offsets of all edits, later edits take precedence
+	persistDirectory := make(map[uint32]uint32)
+	var docPersistIdRef uint32
+	visited := make(map[uint32]bool)
+	for offset := binary.LittleEndian.Uint32(body[8:]); ; {
+		if visited[offset] {
+			return nil, fmt.Errorf("%w: %s: cyclic edit chain", ErrCorruptVbaProject, powerPointDocumentStream)
+		}
+		visited[offset] = true
+		rh, userEdit, err := readPowerPointRecord(document, offset)
+		if err != nil {
+			return nil, err
+		}
+		// lastSlideIdRef, version, minorVersion, majorVersion, offsetLastEdit, offsetPersistDirectory, docPersistIdRef
+		if rh.recType != rtUserEditAtom || len(userEdit) < 20 {
+			return nil, fmt.Errorf("%w: %s: no UserEditAtom at offset %d", ErrCorruptVbaProject, powerPointDocumentStream, offset)
+		}
+		if len(visited) == 1 {
+			docPersistIdRef = binary.LittleEndian.Uint32(userEdit[16:])
+		}
+		if err := readPersistDirectory(document, binary.LittleEndian.Uint32(userEdit[12:]), persistDirectory); err != nil {
+			return nil, err
+		}
+		if offset = binary.LittleEndian.Uint32(userEdit[8:]); offset == 0 {
+			break
+		}
+	}
+
+	// The VBAInfoAtom of the document refers to the persist object of the project
+	docOffset, ok := persistDirectory[docPersistIdRef]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: document persist object %d missing", ErrCorruptVbaProject, powerPointDocumentStream, docPersistIdRef)
+	}
+	rh, container, err := readPowerPointRecord(document, docOffset)
+	if err != nil {
+		return nil, err
+	}
+	if rh.recType != rtDocument {
+		return nil, fmt.Errorf("%w: %s: no DocumentContainer at offset %d", ErrCorruptVbaProject, powerPointDocumentStream, docOffset)
+	}
+	for _, recType := range []uint16{rtDocInfoList, rtVbaInfo, rtVbaInfoAtom} {
+		if container, err = findPowerPointRecord(container, recType); err != nil {
+			return nil, err
+		}
+		if container == nil {
+			return nil, fmt.Errorf("%w: %s has no VBAInfoAtom", ErrNoVbaProject, powerPointDocumentStream)
+		}
+	}
+	// persistIdRef, fHasMacros, version
+	if len(container) < 12 {
+		return nil, fmt.Errorf("%w: %s: VBAInfoAtom truncated", ErrCorruptVbaProject, powerPointDocumentStream)
+	}
+	// The atom is kept when the macros are removed
+	if binary.LittleEndian.Uint32(container[4:]) == 0 {
+		return nil, fmt.Errorf("%w: %s has no macros", ErrNoVbaProject, powerPointDocumentStream)
+	}
+	persistIdRef := binary.LittleEndian.Uint32(container)
+	stgOffset, ok := persistDirectory[persistIdRef]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: VBA project persist object %d missing", ErrCorruptVbaProject, powerPointDocumentStream, persistIdRef)
+	}
+	rh, stg, err := readPowerPointRecord(document, stgOffset)
+	if err != nil {
+		return nil, err
+	}
+	if rh.recType != rtExOleObjStg {
+		return nil, fmt.Errorf("%w: %s: no ExOleObjStg at offset %d", ErrCorruptVbaProject, powerPointDocumentStream, stgOffset)
+	}
+	if rh.instance == 0 {
+		return stg, nil
+	}
+	// Compressed: decompressed size, zlib stream
+	if len(stg) < 4 {
+		return nil, fmt.Errorf("%w: %s: ExOleObjStg truncated", ErrCorruptVbaProject, powerPointDocumentStream)
+	}
+	size := binary.LittleEndian.Uint32(stg)
+	zr, err := zlib.NewReader(bytes.NewReader(stg[4:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: VBA project storage: %v", ErrCorruptVbaProject, powerPointDocumentStream, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: VBA project storage: %v", ErrCorruptVbaProject, powerPointDocumentStream, err)
+	}
+	if uint32(len(data)) != size {
+		return nil, fmt.Errorf("%w: %s: VBA project storage has %d bytes, header says %d", ErrCorruptVbaProject, powerPointDocumentStream, len(data), size)
+	}
+	return data, nil
+}
+
+// Adds the entries of the PersistDirectoryAtom at offset to the persist directory, unless already present
+func readPersistDirectory(document []byte, offset uint32, persistDirectory map[uint32]uint32) error {
+	rh, entries, err := readPowerPointRecord(document, offset)
+	if err != nil {
+		return err
+	}
+	if rh.recType != rtPersistDirectoryAtom {
+		return fmt.Errorf("%w: %s: no PersistDirectoryAtom at offset %d", ErrCorruptVbaProject, powerPointDocumentStream, offset)
+	}
+	// Entries: persistId (20 bits) and cPersist (12 bits), followed by cPersist offsets
+	for len(entries) >= 4 {
+		v := binary.LittleEndian.Uint32(entries)
+		persistId, count := v&0xFFFFF, int(v>>20)
+		entries = entries[4:]
+		if len(entries) < 4*count {
+			return fmt.Errorf("%w: %s: PersistDirectoryAtom truncated", ErrCorruptVbaProject, powerPointDocumentStream)
+		}
+		for i := range count {
+			if _, ok := persistDirectory[persistId+uint32(i)]; !ok {
+				persistDirectory[persistId+uint32(i)] = binary.LittleEndian.Uint32(entries[4*i:])
+			}
+		}
+		entries = entries[4*count:]
+	}
+	return nil
+}
+
+type powerPointRecordHeader struct {
+	instance uint16
+	recType  uint16
+}
+
+// Reads the record at offset and returns its header and content
+func readPowerPointRecord(data []byte, offset uint32) (powerPointRecordHeader, []byte, error) {
+	if uint64(offset)+8 > uint64(len(data)) {
+		return powerPointRecordHeader{}, nil, fmt.Errorf("%w: record at offset %d beyond the end of the stream", ErrCorruptVbaProject, offset)
+	}
+	rh := powerPointRecordHeader{
+		instance: binary.LittleEndian.Uint16(data[offset:]) >> 4,
+		recType:  binary.LittleEndian.Uint16(data[offset+2:]),
+	}
+	length := binary.LittleEndian.Uint32(data[offset+4:])
+	if uint64(offset)+8+uint64(length) > uint64(len(data)) {
+		return rh, nil, fmt.Errorf("%w: record 0x%04X at offset %d exceeds the stream", ErrCorruptVbaProject, rh.recType, offset)
+	}
+	return rh, data[offset+8 : offset+8+length], nil
+}
+
+// Returns the content of the first child record of the given type in the content of a container, nil if not present
+func findPowerPointRecord(container []byte, recType uint16) ([]byte, error) {
+	for offset := uint32(0); offset < uint32(len(container)); {
+		rh, body, err := readPowerPointRecord(container, offset)
+		if err != nil {
+			return nil, err
+		}
+		if rh.recType == recType {
+			return body, nil
+		}
+		offset += 8 + uint32(len(body))
+	}
+	return nil, nil
+}
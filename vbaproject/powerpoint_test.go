@@ -0,0 +1,207 @@
+package vbaproject
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+)
+
+// Binary PowerPoint file with the VBA project storage embedded, the way PowerPoint saves incrementally: the compressed
+// storage, a persist directory for it and a new user edit are appended to the PowerPoint Document stream
+func testPowerPointDocument(t *testing.T, vbaStorage *cfb.Storage) []byte {
+	t.Helper()
+	data, err := os.ReadFile("../testdata/NoMacros.ppt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := cfb.Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentUser := slices.Clone(root.Stream(powerPointCurrentUser).Data)
+	document := slices.Clone(root.Stream(powerPointDocumentStream).Data)
+	record := func(instance uint16, recType uint16, body []byte) []byte {
+		rh := make([]byte, 8)
+		binary.LittleEndian.PutUint16(rh, instance<<4)
+		binary.LittleEndian.PutUint16(rh[2:], recType)
+		binary.LittleEndian.PutUint32(rh[4:], uint32(len(body)))
+		return append(rh, body...)
+	}
+	const persistId = 0x8000
+
+	// The file had macros once, the VBAInfoAtom is still present
+	atom := bytes.Index(document, []byte{0x02, 0x00, 0x00, 0x04, 0x0C, 0x00, 0x00, 0x00})
+	if atom < 0 {
+		t.Fatal("VBAInfoAtom not found")
+	}
+	binary.LittleEndian.PutUint32(document[atom+8:], persistId)
+	binary.LittleEndian.PutUint32(document[atom+12:], 1)
+
+	// ExOleObjStg (compressed) with the storage
+	var storage, compressed bytes.Buffer
+	if _, err := vbaStorage.WriteTo(&storage); err != nil {
+		t.Fatal(err)
+	}
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(storage.Bytes())
+	zw.Close()
+	stgOffset := uint32(len(document))
+	document = append(document, record(1, rtExOleObjStg, append(binary.LittleEndian.AppendUint32(nil, uint32(storage.Len())), compressed.Bytes()...))...)
+
+	// PersistDirectoryAtom with the storage and UserEditAtom pointing to the previous edit
+	persistOffset := uint32(len(document))
+	document = append(document, record(0, rtPersistDirectoryAtom, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, persistId|1<<20), stgOffset))...)
+	lastEdit := binary.LittleEndian.Uint32(currentUser[16:])
+	_, userEdit, err := readPowerPointRecord(document, lastEdit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userEdit = slices.Clone(userEdit)
+	binary.LittleEndian.PutUint32(userEdit[8:], lastEdit)
+	binary.LittleEndian.PutUint32(userEdit[12:], persistOffset)
+	binary.LittleEndian.PutUint32(currentUser[16:], uint32(len(document)))
+	document = append(document, record(0, rtUserEditAtom, userEdit)...)
+
+	root.SetStream(powerPointCurrentUser, currentUser)
+	root.SetStream(powerPointDocumentStream, document)
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// VBA project storage of a binary Office file signed with all signature versions
+func signedVbaStorage(t *testing.T, storageName string, documentStream string) *cfb.Storage {
+	t.Helper()
+	doc := testCompoundDocument(t, storageName, documentStream)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), so); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cfb.Read(bytes.NewReader(signed.Bytes()), int64(signed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root.Storage(storageName)
+}
+
+func TestReadPowerPoint(t *testing.T) {
+	xlsm := testDocument(t)
+	want, err := ReadVbaProject(bytes.NewReader(xlsm), int64(len(xlsm)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppt := testPowerPointDocument(t, signedVbaStorage(t, "_VBA_PROJECT_CUR", "Workbook"))
+	got, err := ReadVbaProject(bytes.NewReader(ppt), int64(len(ppt)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ModuleStream.Modules) != len(want.ModuleStream.Modules) {
+		t.Fatalf("%d modules, want %d", len(got.ModuleStream.Modules), len(want.ModuleStream.Modules))
+	}
+	for i, m := range got.ModuleStream.Modules {
+		if m.Name != want.ModuleStream.Modules[i].Name || !bytes.Equal(m.Raw, want.ModuleStream.Modules[i].Raw) {
+			t.Errorf("module %s differs from %s", m.Name, want.ModuleStream.Modules[i].Name)
+		}
+	}
+}
+
+func TestVerifyPowerPoint(t *testing.T) {
+	// PowerPoint does not document the signature streams, both blob formats are accepted
+	for _, test := range []struct {
+		name                        string
+		storageName, documentStream string
+	}{
+		{"DigSigBlob", "_VBA_PROJECT_CUR", "Workbook"},
+		{"WordSigBlob", "Macros", "WordDocument"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ppt := testPowerPointDocument(t, signedVbaStorage(t, test.storageName, test.documentStream))
+			results, err := VerifyDocument(bytes.NewReader(ppt), int64(len(ppt)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 3 {
+				t.Fatalf("%d signatures verified, want 3", len(results))
+			}
+			for i, r := range results {
+				if !r.Valid {
+					t.Errorf("%s", r)
+				}
+				if want := `PowerPoint Document/\x05` + SignatureVersions[i].StreamName()[1:]; r.PartName != want {
+					t.Errorf("signature %s listed as %s, want %s", r.Version, r.PartName, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPowerPointWithoutMacros(t *testing.T) {
+	data, err := os.ReadFile("../testdata/NoMacros.ppt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseVbaProject(bytes.NewReader(data)); !errors.Is(err, ErrNoVbaProject) {
+		t.Errorf("ParseVbaProject: got error %v, want ErrNoVbaProject", err)
+	}
+	if _, err := VerifyDocument(bytes.NewReader(data), int64(len(data)), ""); !errors.Is(err, ErrNoVbaProject) {
+		t.Errorf("VerifyDocument: got error %v, want ErrNoVbaProject", err)
+	}
+}
+
+func TestPowerPointReadOnly(t *testing.T) {
+	ppt := testPowerPointDocument(t, signedVbaStorage(t, "_VBA_PROJECT_CUR", "Workbook"))
+	r, size := bytes.NewReader(ppt), int64(len(ppt))
+	for name, err := range map[string]error{
+		"SignDocument":      SignDocument(&bytes.Buffer{}, r, size, "", testIdentity(t), SignOptions{IncludeV3: true}),
+		"UnsignDocument":    UnsignDocument(&bytes.Buffer{}, r, size),
+		"TimestampDocument": TimestampDocument(&bytes.Buffer{}, r, size, "", SignOptions{TimestampURL: "http://127.0.0.1:1"}),
+	} {
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s: got error %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+}
+
+func TestCorruptPowerPoint(t *testing.T) {
+	ppt := testPowerPointDocument(t, signedVbaStorage(t, "_VBA_PROJECT_CUR", "Workbook"))
+	root, err := cfb.Read(bytes.NewReader(ppt), int64(len(ppt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentUser := root.Stream(powerPointCurrentUser).Data
+	document := root.Stream(powerPointDocumentStream).Data
+	tests := []struct {
+		name   string
+		modify func(currentUser, document []byte) ([]byte, []byte)
+	}{
+		{"edit beyond the stream", func(cu, doc []byte) ([]byte, []byte) {
+			binary.LittleEndian.PutUint32(cu[16:], uint32(len(doc)))
+			return cu, doc
+		}},
+		{"cyclic edits", func(cu, doc []byte) ([]byte, []byte) {
+			edit := binary.LittleEndian.Uint32(cu[16:])
+			binary.LittleEndian.PutUint32(doc[edit+16:], edit)
+			return cu, doc
+		}},
+		{"truncated stream", func(cu, doc []byte) ([]byte, []byte) {
+			return cu, doc[:len(doc)-100]
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cu, doc := test.modify(slices.Clone(currentUser), slices.Clone(document))
+			if _, err := extractPowerPointVbaProject(cu, doc); !errors.Is(err, ErrCorruptVbaProject) {
+				t.Errorf("got error %v, want ErrCorruptVbaProject", err)
+			}
+		})
+	}
+}
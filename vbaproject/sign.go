@@ -1,162 +1,379 @@
 package vbaproject
 
 import (
-	"archive/zip"
-	"bytes"
-	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"slices"
 	"strings"
+	"time"
 
-	"github.com/coffeeforyou/vbasig/util"
+	"github.com/coffeeforyou/vbasig/opc"
+	"github.com/coffeeforyou/vbasig/pkcs7"
 	"github.com/coffeeforyou/vbasig/vbasigfile"
 )
 
-func SignVbaProject(officeFilePath string, certPath string, keyPath string, caPath string, so SignOptions) {
+// Signs a macro-enabled Office file (e.g. .xlsm, .xlsb, .docm, .pptm, .vsdm, .xls, .doc) and writes the signed file as <name>-signed.<ext> next to it.
+// For a standalone vbaProject.bin, the signature parts are written next to it instead (see SignVbaProjectParts).
+func SignVbaProject(officeFilePath string, certPath string, keyPath string, caPath string, so SignOptions) error {
 	// Try to load provided key material
-	signCert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	util.TerminateIfErr(err)
-	var caCerts []*x509.Certificate
-	if caPath != "" {
-		caCert, err := util.LoadPemCertificate(caPath)
-		util.TerminateIfErr(err)
-		caCerts = append(caCerts, caCert)
+	id, err := LoadIdentity(certPath, keyPath, caPath)
+	if err != nil {
+		return err
 	}
+	return SignVbaProjectWithIdentity(officeFilePath, id, so)
+}
+
+// Like SignVbaProject, with key material from any source (e.g. a crypto.Signer backed by a HSM, see NewIdentity)
+func SignVbaProjectWithIdentity(officeFilePath string, id *Identity, so SignOptions) error {
+	_, err := SignVbaProjectWithReport(officeFilePath, id, so)
+	return err
+}
+
+// Like SignVbaProjectWithIdentity, returns the signatures added, replaced and removed.
+// For a standalone vbaProject.bin, the signature parts written next to it are reported as added or replaced and those
+// of versions no longer included as removed.
+func SignVbaProjectWithReport(officeFilePath string, id *Identity, so SignOptions) (*SignReport, error) {
 	// Open original file
-	zipReader, err := zip.OpenReader(officeFilePath)
-	util.TerminateIfErr(err)
-	defer zipReader.Close()
-
-	// Type of Office file
-	var pathToVba, newFileExt string
-	switch filepath.Ext(officeFilePath) {
-	case ".docm":
-		pathToVba = "word"
-		newFileExt = "docm"
-	case ".xlsm":
-		pathToVba = "xl"
-		newFileExt = "xlsm"
-	case ".pptm":
-		pathToVba = "ppt"
-		newFileExt = "pptm"
-	default:
-		util.TerminateIfErr(fmt.Errorf("unknown file extension: %s", filepath.Ext(officeFilePath)))
-	}
-
-	// Load VBA project
-	vbaProjectFile, err := zipReader.Open(fmt.Sprintf("%s/vbaProject.bin", pathToVba))
-	util.TerminateIfErr(err)
-	// Read and decompress VBA project
-	vbaProjectFileBytes, err := io.ReadAll(vbaProjectFile)
-	util.TerminateIfErr(err)
-	vbaProjectFileOs := bytes.NewReader(vbaProjectFileBytes)
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer officeFile.Close()
 
-	// Parse VBA project and generate signatures
-	vbaProject, err := ParseVbaProject(vbaProjectFileOs)
-	util.TerminateIfErr(err)
+	// A standalone vbaProject.bin is not modified, the signature parts are written next to it
+	if isStandaloneVbaProject(officeFile, size) {
+		return signStandaloneVbaProject(officeFilePath, officeFile, id, so)
+	}
 
-	// Base name of new file
-	baseName := strings.TrimSuffix(officeFilePath, filepath.Ext(officeFilePath))
+	// Nothing is written if the file is already signed as requested
+	if so.SkipIfSigned && isSignedBy(officeFile, size, id, so) {
+		return &SignReport{Unchanged: true}, nil
+	}
 
 	// Create new xlsm/docm file
-	zipfile, err := os.Create(fmt.Sprintf("%s-signed.%s", baseName, newFileExt))
-	util.TerminateIfErr(err)
-	defer zipfile.Close()
-
-	// Zip writer
-	zipWriter := zip.NewWriter(zipfile)
-	defer zipWriter.Close()
-
-	// GENERATE V1 SIGNATURE
-	if so.IncludeV1 {
-		signatureBytes, err := GetProjectSignatureV1(vbaProject, &signCert, caCerts)
-		util.TerminateIfErr(err)
-		signatureFile, err := vbasigfile.NewDigSigInfoSerialized(signatureBytes, *signCert.Leaf)
-		util.TerminateIfErr(err)
-		// New signature file to add to the zip
-		sigFile, err := zipWriter.Create(fmt.Sprintf("%s/vbaProjectSignature.bin", pathToVba))
-		util.TerminateIfErr(err)
-		// Write signature to ZIP file
-		_, err = sigFile.Write(signatureFile.Serialize())
-		util.TerminateIfErr(err)
-	}
-
-	// GENERATE AGILE SIGNATURE
-	if so.IncludeAgile {
-		signatureBytesAgile, err := GetProjectSignatureAgile(vbaProject, &signCert, caCerts)
-		util.TerminateIfErr(err)
-		signatureFileAgile, err := vbasigfile.NewDigSigInfoSerialized(signatureBytesAgile, *signCert.Leaf)
-		util.TerminateIfErr(err)
-		// New signature file to add to the zip
-		sigFileAgile, err := zipWriter.Create(fmt.Sprintf("%s/vbaProjectSignatureAgile.bin", pathToVba))
-		util.TerminateIfErr(err)
-		// Write signature to ZIP file
-		_, err = sigFileAgile.Write(signatureFileAgile.Serialize())
-		util.TerminateIfErr(err)
-	}
-
-	// GENERATE V3 SIGNATURE
-	if so.IncludeV3 {
-		signatureBytesV3, err := GetProjectSignatureV3(vbaProject, &signCert, caCerts)
-		util.TerminateIfErr(err)
-		signatureFileV3, err := vbasigfile.NewDigSigInfoSerialized(signatureBytesV3, *signCert.Leaf)
-		util.TerminateIfErr(err)
-		// New signature file to add to the zip
-		sigFileV3, err := zipWriter.Create(fmt.Sprintf("%s/vbaProjectSignatureV3.bin", pathToVba))
-		util.TerminateIfErr(err)
-		// Write signature to ZIP file
-		_, err = sigFileV3.Write(signatureFileV3.Serialize())
-		util.TerminateIfErr(err)
+	var vbaProject *VbaProject
+	var report *SignReport
+	signedFilePath, err := createFileNextTo(officeFilePath, "signed", func(w io.Writer) error {
+		vbaProject, report, err = signDocument(w, officeFile, size, id, so)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Record sections of the VBA project to locate changes later
+	if so.WriteManifest {
+		if err = NewManifest(vbaProject).Save(ManifestPath(signedFilePath)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// Signs an Office file read from r (size bytes) and writes the signed file to w.
+// The VBA project is located through the package relationships, with the content types as fallback, and binary Office
+// files (.xls, .doc) are detected from the content, so the file type (extension, e.g. "xlsm") may be empty. A file type
+// given must match the content (compound file or Office Open XML package), otherwise ErrUnsupportedFormat is returned.
+// Signatures of versions not included in the SignOptions are removed together with their relationships and content type overrides.
+// SignOptions.WriteManifest is ignored, use NewManifest and ReadVbaProject to record a manifest.
+// With SignOptions.SkipIfSigned nothing is written if the document is already signed as requested, see SignDocumentWithReport.
+func SignDocument(w io.Writer, r io.ReaderAt, size int64, fileType string, id *Identity, so SignOptions) error {
+	_, err := SignDocumentWithReport(w, r, size, fileType, id, so)
+	return err
+}
+
+// Like SignDocument, returns the signatures added, replaced and removed.
+// With SignOptions.SkipIfSigned, nothing is written to w if the document is already signed as requested (SignReport.Unchanged).
+func SignDocumentWithReport(w io.Writer, r io.ReaderAt, size int64, fileType string, id *Identity, so SignOptions) (*SignReport, error) {
+	if err := checkFileType(r, fileType); err != nil {
+		return nil, err
+	}
+	if so.SkipIfSigned && isSignedBy(r, size, id, so) {
+		return &SignReport{Unchanged: true}, nil
+	}
+	_, report, err := signDocument(w, r, size, id, so)
+	return report, err
+}
+
+// Reports whether the document has valid signatures of all versions included in the SignOptions, made with the
+// certificate of the identity, and no signatures of other versions unless they are kept, i.e. signing would not change it
+func isSignedBy(r io.ReaderAt, size int64, id *Identity, so SignOptions) bool {
+	if id.Certificate == nil {
+		return false
+	}
+	results, err := VerifyDocument(r, size, "")
+	if err != nil {
+		return false
+	}
+	signed := 0
+	for _, sv := range results {
+		if !slices.Contains(so.versions(), sv.Version) {
+			if !so.KeepSignatures || !sv.Valid {
+				return false
+			}
+			continue
+		}
+		if !sv.Valid || sv.Signer == nil || !sv.Signer.Equal(id.Certificate) {
+			return false
+		}
+		signed++
+	}
+	return signed == len(so.versions())
+}
+
+// Signs the document and returns the parsed VBA project
+func signDocument(w io.Writer, r io.ReaderAt, size int64, id *Identity, so SignOptions) (*VbaProject, *SignReport, error) {
+	if err := id.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := so.checkDeterministic(id); err != nil {
+		return nil, nil, err
+	}
+	return writeSignedDocument(w, r, size, id.Certificate, so, func(p *VbaProject) ([]projectSignature, error) {
+		return createSignatures(p, id, so)
+	})
+}
+
+// Creates the signatures of a VBA project, e.g. by signing it or from signatures created elsewhere
+type signFunc func(p *VbaProject) ([]projectSignature, error)
+
+// Changes of the signatures made when signing, by part name (stream name in binary Office files)
+type SignReport struct {
+	Added     []string // signatures written where none was present
+	Replaced  []string // existing signatures overwritten
+	Removed   []string // signatures of versions not written, removed together with their relationships and content type overrides
+	Kept      []string // signatures of versions not written, kept because of SignOptions.KeepSignatures
+	Unchanged bool     // the document was already signed as requested and not rewritten (SignOptions.SkipIfSigned)
+}
+
+func (r *SignReport) String() string {
+	if r.Unchanged {
+		return "already signed, unchanged"
+	}
+	var lines []string
+	for _, c := range []struct {
+		action string
+		names  []string
+	}{{"added", r.Added}, {"replaced", r.Replaced}, {"removed", r.Removed}, {"kept", r.Kept}} {
+		for _, name := range c.names {
+			lines = append(lines, fmt.Sprintf("%s %s", c.action, name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Adds a signature of the given version to the report, depending on whether it was present and is written
+func (r *SignReport) add(name string, present bool, written bool) {
+	switch {
+	case written && present:
+		r.Replaced = append(r.Replaced, name)
+	case written:
+		r.Added = append(r.Added, name)
+	case present:
+		r.Removed = append(r.Removed, name)
+	}
+}
+
+// Writes the document with the signatures created by sign for its VBA project and returns the parsed VBA project
+// and the changes of the signatures. The signatures must be of the versions included in the SignOptions, signCert
+// is stored with each signature. Relationships and content type overrides of signatures not written are removed.
+func writeSignedDocument(w io.Writer, r io.ReaderAt, size int64, signCert *x509.Certificate, so SignOptions, sign signFunc) (*VbaProject, *SignReport, error) {
+	if isCompoundFile(r) {
+		return signCompoundDocument(w, r, size, signCert, so, sign)
+	}
+
+	// Open original file
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Parse VBA project and generate signatures
+	vbaProject, vbaPart, err := readVbaProject(pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Existing signatures to keep are checked before signing
+	var kept []SignatureVersion
+	for _, version := range SignatureVersions {
+		if !so.keeps(version) {
+			continue
+		}
+		partName, signatureBytes, err := readSignaturePart(pkg, vbaPart, version)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		sv := verifySignature(vbaProject, version, signatureBytes, VerifyOptions{})
+		sv.PartName = partName
+		if err = checkKeptSignature(sv); err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, version)
+	}
+
+	signatures, err := sign(vbaProject)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Existing signature parts are replaced where they are, new ones get the conventional names
+	partNames := findSignatureParts(pkg, vbaPart)
+	var written []SignatureVersion
+	for _, sig := range signatures {
+		written = append(written, sig.version)
 	}
 
 	// Read relationships to ensure that VBA rels are present
-	relFileOrig, err := zipReader.Open(fmt.Sprintf("%s/_rels/vbaProject.bin.rels", pathToVba))
-	var relFileBytes []byte = []byte(DefaultRels)
-	if err == nil {
-		relFileBytes, err = io.ReadAll(relFileOrig)
-		util.TerminateIfErr(err)
+	relsPart := opc.RelationshipsPartName(vbaPart)
+	relFileBytes, err := pkg.ReadPart(relsPart)
+	if errors.Is(err, fs.ErrNotExist) {
+		relFileBytes, err = []byte(DefaultRels), nil
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 	// Update XML structure if needed
-	relFileBytes, err = AddRels(relFileBytes, pathToVba, so)
-	util.TerminateIfErr(err)
-	// Write updated content types to ZIP
-	relFile, err := zipWriter.Create(fmt.Sprintf("%s/_rels/vbaProject.bin.rels", pathToVba))
-	util.TerminateIfErr(err)
-	_, err = relFile.Write(relFileBytes)
-	util.TerminateIfErr(err)
+	relFileBytes, relsRemoved, err := updateRels(relFileBytes, vbaPart, partNames, append(slices.Clone(written), kept...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("updating relationships failed: %w", err)
+	}
 
 	// Read content types to ensure that VBA types are present
-	ctFileOrig, err := zipReader.Open("[Content_Types].xml")
-	util.TerminateIfErr(err)
-	// Read content types file
-	ctFileBytes, err := io.ReadAll(ctFileOrig)
-	util.TerminateIfErr(err)
+	ctFileBytes, err := pkg.ReadPart(opc.ContentTypesName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s missing", ErrUnsupportedFormat, opc.ContentTypesName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 	// Update XML structure if needed
-	ctFileBytes, err = AddContentTypes(ctFileBytes, pathToVba, so)
-	util.TerminateIfErr(err)
-	// Write updated content types to ZIP
-	ctFile, err := zipWriter.Create("[Content_Types].xml")
-	util.TerminateIfErr(err)
-	_, err = ctFile.Write(ctFileBytes)
-	util.TerminateIfErr(err)
-
-	for _, entry := range zipReader.File {
-		if strings.HasSuffix(entry.Name, "vbaProjectSignature.bin") ||
-			strings.HasSuffix(entry.Name, "vbaProjectSignatureAgile.bin") ||
-			strings.HasSuffix(entry.Name, "vbaProjectSignatureV3.bin") ||
-			strings.HasSuffix(entry.Name, "bin.rels") ||
-			strings.HasSuffix(entry.Name, "[Content_Types].xml") {
+	ctFileBytes, overridesRemoved, err := updateContentTypes(ctFileBytes, partNames, append(slices.Clone(written), kept...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("updating content types failed: %w", err)
+	}
+
+	// Existing signatures of versions not included are dropped unless kept
+	report := &SignReport{}
+	for _, version := range SignatureVersions {
+		name := partNames[version]
+		if slices.Contains(kept, version) {
+			report.Kept = append(report.Kept, name)
 			continue
 		}
-		writer, err := zipWriter.Create(entry.Name)
-		util.TerminateIfErr(err)
-		reader, err := entry.Open()
-		util.TerminateIfErr(err)
-		_, err = io.Copy(writer, reader)
-		util.TerminateIfErr(err)
-		reader.Close()
+		exists := pkg.Part(name) != nil
+		present := exists || slices.Contains(relsRemoved, version) || slices.Contains(overridesRemoved, version)
+		report.add(name, present, slices.Contains(written, version))
+		if exists && !slices.Contains(written, version) {
+			pkg.RemovePart(name)
+		}
+	}
+
+	// Signature parts, relationships and content types replace the existing parts, all others are copied raw
+	for _, sig := range signatures {
+		signatureFile, err := serializeSignature(sig.signature, signCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		pkg.SetPart(partNames[sig.version], signatureFile)
+	}
+	pkg.SetPart(relsPart, relFileBytes)
+	pkg.SetPart(opc.ContentTypesName, ctFileBytes)
+	if err = pkg.Write(w, so.partTime()); err != nil {
+		return nil, nil, err
+	}
+	return vbaProject, report, nil
+}
+
+// An existing signature can only be kept if it is still valid for the VBA project
+func checkKeptSignature(sv SignatureVerification) error {
+	if !sv.Valid {
+		return fmt.Errorf("existing %s signature (%s) cannot be kept: %s", sv.Version, sv.PartName, sv.Reason)
+	}
+	return nil
+}
+
+type projectSignature struct {
+	version   SignatureVersion
+	signature []byte // PKCS#7 signed data
+}
+
+// Creates the signatures of the versions included in the SignOptions
+func createSignatures(p *VbaProject, id *Identity, so SignOptions) ([]projectSignature, error) {
+	var res []projectSignature
+	for _, version := range so.versions() {
+		signatureBytes, err := getProjectSignature(p, version, id, so.SigningTime)
+		if err != nil {
+			return nil, fmt.Errorf("%s signature: %w", version, err)
+		}
+		if signatureBytes, err = timestampSignature(signatureBytes, so); err != nil {
+			return nil, fmt.Errorf("%s timestamp: %w", version, err)
+		}
+		res = append(res, projectSignature{version: version, signature: signatureBytes})
+	}
+	return res, nil
+}
+
+// Returns the signature (PKCS#7) of the given version, with the PKCS#9 signing time as signed attribute unless zero
+func getProjectSignature(p *VbaProject, version SignatureVersion, id *Identity, signingTime time.Time) ([]byte, error) {
+	var attrs []pkcs7.Attribute
+	if !signingTime.IsZero() {
+		// Signatures with a signing time outside of the validity of the certificate do not verify
+		if signingTime.Before(id.Certificate.NotBefore) || signingTime.After(id.Certificate.NotAfter) {
+			return nil, fmt.Errorf("signing time %s outside of the validity of the signing certificate (%s to %s)", signingTime.Format(time.RFC3339),
+				id.Certificate.NotBefore.Format(time.RFC3339), id.Certificate.NotAfter.Format(time.RFC3339))
+		}
+		attrs = append(attrs, pkcs7.Attribute{Type: pkcs7.OIDAttributeSigningTime, Value: signingTime.UTC()})
+	}
+	switch version {
+	case SignatureV1:
+		return GetProjectSignatureV1(p, id.Signer, id.Certificate, id.CACerts, attrs...)
+	case SignatureAgile:
+		return GetProjectSignatureAgile(p, id.Signer, id.Certificate, id.CACerts, attrs...)
+	case SignatureV3:
+		return GetProjectSignatureV3(p, id.Signer, id.Certificate, id.CACerts, attrs...)
+	}
+	return nil, fmt.Errorf("unknown signature version %s", version)
+}
+
+// Returns the content (SpcIndirectDataContent) signed by the signature of the given version
+func getContentInfo(p *VbaProject, version SignatureVersion) ([]byte, error) {
+	switch version {
+	case SignatureV1:
+		return p.GetContentInfo()
+	case SignatureAgile:
+		return GetContentInfoV2(p)
+	case SignatureV3:
+		return GetContentInfoV3(p)
+	}
+	return nil, fmt.Errorf("unknown signature version %s", version)
+}
+
+// Adds timestamps (RFC 3161 tokens or legacy countersignatures) to the signature if a TSA is configured
+func timestampSignature(signatureBytes []byte, so SignOptions) ([]byte, error) {
+	if so.TimestampURL == "" {
+		return signatureBytes, nil
+	}
+	p7, err := pkcs7.Parse(signatureBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = p7.AddTimestamps(&pkcs7.TimestampClient{URL: so.TimestampURL, Legacy: so.TimestampLegacy}); err != nil {
+		return nil, err
+	}
+	return p7.Marshal()
+}
+
+// Serializes the signature (DigSigInfoSerialized) with the signing certificate
+func serializeSignature(signatureBytes []byte, signCert *x509.Certificate) ([]byte, error) {
+	signatureFile, err := vbasigfile.NewDigSigInfoSerialized(signatureBytes, *signCert)
+	if err != nil {
+		return nil, err
 	}
+	return signatureFile.Serialize(), nil
 }
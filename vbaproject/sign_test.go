@@ -0,0 +1,493 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/opc"
+	"github.com/coffeeforyou/vbasig/pkcs7"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+// The signing identity is shared by the tests, RSA key generation is slow
+var (
+	testIdentityOnce sync.Once
+	testIdentityKey  *rsa.PrivateKey
+	testIdentityErr  error
+)
+
+// Identity with a self-signed code signing certificate valid for an hour around now
+func testIdentity(t *testing.T) *Identity {
+	t.Helper()
+	return testIdentityNamed(t, "vbasig test signer")
+}
+
+// Like testIdentity, with the given common name, e.g. for another certificate of the same key
+func testIdentityNamed(t *testing.T, commonName string) *Identity {
+	t.Helper()
+	testIdentityOnce.Do(func() {
+		testIdentityKey, testIdentityErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	if testIdentityErr != nil {
+		t.Fatal(testIdentityErr)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, testIdentityKey.Public(), testIdentityKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewIdentity(testIdentityKey, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Macro-enabled workbook with an unsigned VBA project
+func testDocument(t *testing.T) []byte {
+	t.Helper()
+	doc, err := os.ReadFile("../testdata/Book1.xlsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// Binary Office file with the VBA project of testDocument in the given storage (_VBA_PROJECT_CUR for Excel, Macros
+// for Word) next to a dummy document stream (Workbook or WordDocument)
+func testCompoundDocument(t *testing.T, storageName string, documentStream string) []byte {
+	t.Helper()
+	doc := testDocument(t)
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbaProjectBin, err := pkg.ReadPart("xl/vbaProject.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := cfb.Read(bytes.NewReader(vbaProjectBin), int64(len(vbaProjectBin)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Name = storageName
+	root := &cfb.Storage{Storages: []*cfb.Storage{storage}}
+	root.SetStream(documentStream, bytes.Repeat([]byte("document"), 1000))
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Office file with the VBA project of doc in which line 2 of Module1 is changed ("Sub Button1_Click()" to
+// "Sub Button1_Klick()"), e.g. to tamper with a signed document. The literal is changed in the compressed source code.
+func tamperModule(t *testing.T, doc []byte) []byte {
+	t.Helper()
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbaProjectBin, err := pkg.ReadPart("xl/vbaProject.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := cfb.Read(bytes.NewReader(vbaProjectBin), int64(len(vbaProjectBin)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := root.Storage("VBA").Stream("Module1")
+	if !bytes.Contains(module.Data, []byte("Click")) {
+		t.Fatal("Module1 does not contain Click")
+	}
+	module.Data = bytes.Replace(module.Data, []byte("Click"), []byte("Klick"), 1)
+	var buf bytes.Buffer
+	if _, err := root.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	pkg.SetPart("xl/vbaProject.bin", buf.Bytes())
+	var tampered bytes.Buffer
+	if err := pkg.Write(&tampered, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	return tampered.Bytes()
+}
+
+func TestFileType(t *testing.T) {
+	xlsm, xls := testDocument(t), testCompoundDocument(t, "_VBA_PROJECT_CUR", "Workbook")
+	tests := []struct {
+		name     string
+		doc      []byte
+		fileType string
+		ok       bool
+	}{
+		{"detected", xlsm, "", true},
+		{"matching", xlsm, ".xlsm", true},
+		{"without dot and upper case", xlsm, "XLSM", true},
+		{"renamed without macros", xlsm, ".xlsx", true},
+		{"binary file detected", xls, "", true},
+		{"binary file matching", xls, ".xls", true},
+		{"package as binary file", xlsm, ".xls", false},
+		{"binary file as package", xls, ".xlsm", false},
+		{"unknown", xlsm, ".txt", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, size := bytes.NewReader(test.doc), int64(len(test.doc))
+			for name, err := range map[string]error{
+				"SignDocument": SignDocument(&bytes.Buffer{}, r, size, test.fileType, testIdentity(t), SignOptions{IncludeV3: true}),
+				"ReadVbaProject": func() error {
+					_, err := ReadVbaProject(r, size, test.fileType)
+					return err
+				}(),
+				"VerifyDocument": func() error {
+					_, err := VerifyDocument(r, size, test.fileType)
+					return err
+				}(),
+			} {
+				if test.ok && err != nil {
+					t.Errorf("%s: %v", name, err)
+				} else if !test.ok && !errors.Is(err, ErrUnsupportedFormat) {
+					t.Errorf("%s: got error %v, want ErrUnsupportedFormat", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignDeterministic(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	sign := func(so SignOptions) ([]byte, error) {
+		var signed bytes.Buffer
+		err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, so)
+		return signed.Bytes(), err
+	}
+	signingTime := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	for _, test := range []struct {
+		name        string
+		signingTime time.Time
+	}{
+		{"without signing time", time.Time{}},
+		{"with signing time", signingTime},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true, Deterministic: true, SigningTime: test.signingTime}
+			first, err := sign(so)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := sign(so)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Fatal("signed documents differ")
+			}
+			results, err := VerifyDocument(bytes.NewReader(first), int64(len(first)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 3 {
+				t.Fatalf("got %d signatures, want 3", len(results))
+			}
+			pkg, err := opc.Open(bytes.NewReader(first), int64(len(first)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range results {
+				if r.Version != SignatureVersions[i] {
+					t.Errorf("got %s signature at %d, want %s", r.Version, i, SignatureVersions[i])
+				}
+				if !r.Valid {
+					t.Errorf("%s", r)
+				}
+				_, data, err := readSignaturePart(pkg, "xl/vbaProject.bin", r.Version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				info, err := vbasigfile.ParseDigSigInfoSerialized(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p7, err := pkcs7.Parse(info.PbSignatureBuffer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got time.Time
+				err = p7.UnmarshalSignedAttribute(pkcs7.OIDAttributeSigningTime, &got)
+				if test.signingTime.IsZero() && err == nil {
+					t.Errorf("%s signature has signing time %s, want none", r.Version, got)
+				} else if !test.signingTime.IsZero() && (err != nil || !got.Equal(test.signingTime)) {
+					t.Errorf("%s signature has signing time %s (%v), want %s", r.Version, got, err, test.signingTime)
+				}
+			}
+		})
+	}
+
+	// A signing time the certificate is not valid at would make the signatures invalid
+	so := SignOptions{IncludeV3: true, Deterministic: true, SigningTime: id.Certificate.NotAfter.Add(time.Hour)}
+	if _, err := sign(so); err == nil {
+		t.Error("signing time after the validity of the certificate accepted")
+	}
+}
+
+func TestSignRemovesVersionsNotIncluded(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var resigned bytes.Buffer
+	report, err := SignDocumentWithReport(&resigned, bytes.NewReader(signed.Bytes()), int64(signed.Len()), "", id, SignOptions{IncludeV3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Removed, []string{"xl/vbaProjectSignature.bin", "xl/vbaProjectSignatureAgile.bin"}) ||
+		!slices.Equal(report.Replaced, []string{"xl/vbaProjectSignatureV3.bin"}) || len(report.Added) != 0 || len(report.Kept) != 0 {
+		t.Errorf("got report\n%s", report)
+	}
+
+	pkg, err := opc.Open(bytes.NewReader(resigned.Bytes()), int64(resigned.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []SignatureVersion{SignatureV1, SignatureAgile} {
+		if p := pkg.Part("xl/" + version.FileName()); p != nil {
+			t.Errorf("part %s left", p.Name)
+		}
+	}
+	rels, err := pkg.ReadPart("xl/_rels/vbaProject.bin.rels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentTypes, err := pkg.ReadPart(opc.ContentTypesName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []SignatureVersion{SignatureV1, SignatureAgile} {
+		if bytes.Contains(rels, []byte(`"`+version.RelationshipType()+`"`)) {
+			t.Errorf("%s relationship left in %s", version, rels)
+		}
+		if bytes.Contains(contentTypes, []byte(`"`+version.ContentType()+`"`)) {
+			t.Errorf("%s override left in %s", version, contentTypes)
+		}
+	}
+	if !bytes.Contains(rels, []byte(`"`+SignatureV3.RelationshipType()+`"`)) || !bytes.Contains(contentTypes, []byte(`"`+SignatureV3.ContentType()+`"`)) {
+		t.Error("V3 relationship or override missing")
+	}
+	results, err := VerifyDocument(bytes.NewReader(resigned.Bytes()), int64(resigned.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Version != SignatureV3 || !results[0].Valid {
+		t.Errorf("got %v, want a valid V3 signature only", results)
+	}
+}
+
+func TestSignKeepSignatures(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, SignOptions{IncludeV1: true}); err != nil {
+		t.Fatal(err)
+	}
+	so := SignOptions{IncludeV3: true, KeepSignatures: true}
+
+	t.Run("valid", func(t *testing.T) {
+		v1, v1Relationship := readV1Signature(t, signed.Bytes())
+		var resigned bytes.Buffer
+		report, err := SignDocumentWithReport(&resigned, bytes.NewReader(signed.Bytes()), int64(signed.Len()), "", id, so)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(report.Kept, []string{"xl/vbaProjectSignature.bin"}) || !slices.Equal(report.Added, []string{"xl/vbaProjectSignatureV3.bin"}) ||
+			len(report.Removed) != 0 || len(report.Replaced) != 0 {
+			t.Errorf("got report\n%s", report)
+		}
+		kept, keptRelationship := readV1Signature(t, resigned.Bytes())
+		if !bytes.Equal(kept, v1) {
+			t.Error("kept V1 signature changed")
+		}
+		if keptRelationship != v1Relationship {
+			t.Errorf("V1 relationship changed from %s to %s", v1Relationship, keptRelationship)
+		}
+		results, err := VerifyDocument(bytes.NewReader(resigned.Bytes()), int64(resigned.Len()), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || results[0].Version != SignatureV1 || !results[0].Valid || results[1].Version != SignatureV3 || !results[1].Valid {
+			t.Errorf("got %v, want valid V1 and V3 signatures", results)
+		}
+	})
+
+	t.Run("no longer valid", func(t *testing.T) {
+		tampered := tamperModule(t, signed.Bytes())
+		var resigned bytes.Buffer
+		_, err := SignDocumentWithReport(&resigned, bytes.NewReader(tampered), int64(len(tampered)), "", id, so)
+		if err == nil || !strings.Contains(err.Error(), "V1 signature (xl/vbaProjectSignature.bin) cannot be kept") {
+			t.Errorf("got error %v, want the V1 signature rejected", err)
+		}
+		if resigned.Len() != 0 {
+			t.Error("document written")
+		}
+	})
+}
+
+// Returns the V1 signature part of a signed workbook and its relationship element
+func readV1Signature(t *testing.T, doc []byte) ([]byte, string) {
+	t.Helper()
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkg.ReadPart("xl/vbaProjectSignature.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels, err := pkg.ReadPart("xl/_rels/vbaProject.bin.rels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relationship := regexp.MustCompile(`<Relationship [^>]*Type="` + regexp.QuoteMeta(SignatureV1.RelationshipType()) + `"[^>]*/>`).Find(rels)
+	if relationship == nil {
+		t.Fatalf("no V1 relationship in %s", rels)
+	}
+	return data, string(relationship)
+}
+
+func TestSignSkipIfSigned(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	sign := func(doc []byte, id *Identity, so SignOptions) ([]byte, *SignReport) {
+		t.Helper()
+		var signed bytes.Buffer
+		report, err := SignDocumentWithReport(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, so)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed.Bytes(), report
+	}
+	signedV3, _ := sign(doc, id, SignOptions{IncludeV3: true})
+	signedV1V3, _ := sign(doc, id, SignOptions{IncludeV1: true, IncludeV3: true})
+
+	tests := []struct {
+		name      string
+		doc       []byte
+		id        *Identity
+		so        SignOptions
+		unchanged bool
+	}{
+		{"same certificate", signedV3, id, SignOptions{IncludeV3: true}, true},
+		{"other certificate", signedV3, testIdentityNamed(t, "other signer"), SignOptions{IncludeV3: true}, false},
+		{"version missing", signedV3, id, SignOptions{IncludeV1: true, IncludeV3: true}, false},
+		{"other version present", signedV1V3, id, SignOptions{IncludeV3: true}, false},
+		{"other version present and kept", signedV1V3, id, SignOptions{IncludeV3: true, KeepSignatures: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.so.SkipIfSigned = true
+			signed, report := sign(test.doc, test.id, test.so)
+			if report.Unchanged != test.unchanged {
+				t.Errorf("got report %q, want unchanged %v", report, test.unchanged)
+			}
+			if test.unchanged {
+				if len(signed) != 0 {
+					t.Errorf("%d bytes written for an unchanged document", len(signed))
+				}
+				return
+			}
+			results, err := VerifyDocument(bytes.NewReader(signed), int64(len(signed)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var versions []SignatureVersion
+			for _, r := range results {
+				if !r.Valid || !r.Signer.Equal(test.id.Certificate) {
+					t.Errorf("%s, want valid and signed by %s", r, test.id.Certificate.Subject)
+				}
+				versions = append(versions, r.Version)
+			}
+			if !slices.Equal(versions, test.so.versions()) {
+				t.Errorf("got signatures %v, want %v", versions, test.so.versions())
+			}
+		})
+	}
+}
+
+// Writer that fails after n bytes
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errors.New("write failed")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestSignDocumentStream(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true, Deterministic: true}
+
+	// The file API writes the same document as the stream API
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Book1.xlsm")
+	if err := os.WriteFile(path, doc, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignVbaProjectWithIdentity(path, id, so); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join(dir, "Book1-signed.xlsm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Document read from the middle of a larger buffer (e.g. an upload), with and without file type
+	buf := append(append([]byte("prefix"), doc...), "suffix"...)
+	for _, fileType := range []string{"", ".xlsm", "xlsm"} {
+		var signed bytes.Buffer
+		if err := SignDocument(&signed, io.NewSectionReader(bytes.NewReader(buf), 6, int64(len(doc))), int64(len(doc)), fileType, id, so); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(signed.Bytes(), want) {
+			t.Errorf("file type %q: signed document differs from the one written by SignVbaProjectWithIdentity", fileType)
+		}
+	}
+
+	if err := SignDocument(&failingWriter{n: 1000}, bytes.NewReader(doc), int64(len(doc)), "", id, so); err == nil {
+		t.Error("write error not returned")
+	}
+}
@@ -2,31 +2,36 @@ package vbaproject
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/md5"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/binary"
 	"strings"
 
 	"github.com/coffeeforyou/vbasig/pkcs7"
-	"github.com/coffeeforyou/vbasig/util"
 )
 
-// Returns the pkcs7 (detached signature bytes)
-func GetProjectSignatureV1(p *VbaProject, certWithKey *tls.Certificate, caCerts []*x509.Certificate) ([]byte, error) {
+// Returns the pkcs7 (detached signature bytes), signedAttributes are added to the signed attributes (e.g. the signing time)
+func GetProjectSignatureV1(p *VbaProject, signer crypto.Signer, signCert *x509.Certificate, caCerts []*x509.Certificate, signedAttributes ...pkcs7.Attribute) ([]byte, error) {
 	// Get content info
 	content, err := p.GetContentInfo()
-	util.TerminateIfErr(err)
+	if err != nil {
+		return nil, err
+	}
 	// Initialize signature
 	signature, err := pkcs7.NewSignedMsData(content)
-	util.TerminateIfErr(err)
+	if err != nil {
+		return nil, err
+	}
 	// Setting algorithm to MD5
 	signature.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmMD5)
 	// Add signer
 	msAttribute := pkcs7.Attribute{Type: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}, Value: asn1.NullRawValue}
-	err = signature.AddSignerChain(certWithKey.Leaf, certWithKey.PrivateKey, caCerts, pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{msAttribute}})
-	util.TerminateIfErr(err)
+	err = signature.AddSignerChain(signCert, signer, caCerts, pkcs7.SignerInfoConfig{ExtraSignedAttributes: append([]pkcs7.Attribute{msAttribute}, signedAttributes...)})
+	if err != nil {
+		return nil, err
+	}
 	// Convert to bytes
 	return signature.Finish()
 }
@@ -2,8 +2,8 @@ package vbaproject
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/sha256"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/binary"
@@ -16,20 +16,26 @@ import (
 	"github.com/coffeeforyou/vbasig/vbaproject/dirstream"
 )
 
-// Returns the pkcs7 (detached signature bytes)
-func GetProjectSignatureV3(p *VbaProject, certWithKey *tls.Certificate, caCerts []*x509.Certificate) ([]byte, error) {
+// Returns the pkcs7 (detached signature bytes), signedAttributes are added to the signed attributes (e.g. the signing time)
+func GetProjectSignatureV3(p *VbaProject, signer crypto.Signer, signCert *x509.Certificate, caCerts []*x509.Certificate, signedAttributes ...pkcs7.Attribute) ([]byte, error) {
 	// Get content info
 	content, err := GetContentInfoV3(p)
-	util.TerminateIfErr(err)
+	if err != nil {
+		return nil, err
+	}
 	// Initialize signature
 	signature, err := pkcs7.NewSignedMsData(content)
-	util.TerminateIfErr(err)
+	if err != nil {
+		return nil, err
+	}
 	// Setting algorithm to MD5
 	signature.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
 	// Add signer
 	msAttribute := pkcs7.Attribute{Type: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}, Value: asn1.NullRawValue}
-	err = signature.AddSignerChain(certWithKey.Leaf, certWithKey.PrivateKey, caCerts, pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{msAttribute}})
-	util.TerminateIfErr(err)
+	err = signature.AddSignerChain(signCert, signer, caCerts, pkcs7.SignerInfoConfig{ExtraSignedAttributes: append([]pkcs7.Attribute{msAttribute}, signedAttributes...)})
+	if err != nil {
+		return nil, err
+	}
 	// Convert to bytes
 	return signature.Finish()
 }
@@ -46,7 +52,10 @@ func GetContentInfoV3(p *VbaProject) ([]byte, error) {
 	content.Data.Value = asn1.RawValue{Class: 0, Tag: 4, Bytes: sfdv1Bytes} // SigFormatDescriptorV1 structure
 	content.MessageDigest.DigestAlgorithm = AlgorithmIdentifier{Algorithm: pkcs7.OIDDigestAlgorithmSHA256, Parameters: asn1.NullRawValue}
 	algorithmId := []byte("2.16.840.1.101.3.4.2.1\x00")
-	sourceHash := getHashV3(p)
+	sourceHash, err := getHashV3(p)
+	if err != nil {
+		return nil, err
+	}
 	algorithmIdSize := int32(len(algorithmId))
 	sdv1 := SigDataV1SerializedHeader{
 		AlgorithmIdSize:    algorithmIdSize,
@@ -81,18 +90,23 @@ func GetContentInfoV3(p *VbaProject) ([]byte, error) {
 // APPEND ContentBuffer WITH the V3ContentNormalizedData Buffer, as generated in V3 Content Normalized Data (section 2.4.2.5).
 // APPEND ContentBuffer WITH the ProjectNormalizedData Buffer, as generated in the Project Normalized Data (section 2.4.2.6).
 // SET CryptographicDigest TO the cryptographic digest of ContentBuffer as specified by the hashing algorithm.
-func getHashV3(p *VbaProject) []byte {
-	var res []byte
+func getHashV3(p *VbaProject) ([]byte, error) {
 	h := sha256.New()
-	buf := contentNormalizedDataV3(p)
-	buf = append(buf, projectNormalizedData(p)...)
+	buf, err := contentNormalizedDataV3(p)
+	if err != nil {
+		return nil, err
+	}
+	pnd, err := projectNormalizedData(p)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, pnd...)
 	h.Write(buf)
-	res = h.Sum(nil)
-	return res
+	return h.Sum(nil), nil
 }
 
 // 2.4.2.1 Content Normalized Data
-func contentNormalizedDataV3(p *VbaProject) []byte {
+func contentNormalizedDataV3(p *VbaProject) ([]byte, error) {
 	buf := []byte{}
 	// APPEND Buffer WITH PROJECTSYSKIND.Id (section 2.3.4.2.1.1) of Storage
 	buf, _ = binary.Append(buf, binary.LittleEndian, int16(0x0001)) //  MUST be 0x0001
@@ -235,11 +249,10 @@ func contentNormalizedDataV3(p *VbaProject) []byte {
 			// APPEND Buffer WITH MODULE.PrivateRecord.Reserved (section 2.3.4.2.3.2.10)
 			buf, _ = binary.Append(buf, binary.LittleEndian, int32(0x00000000)) // MUST be 0x00000000
 		}
-		// Get stream
-		moduleName, _ := util.ConvertFromCodepageToUtf8(m.NameRecord.ModuleName, p.DirStream.InformationRecord.CodePage.CodePage)
-		ms := p.ModuleStream.GetModule(moduleName)
+		// Get stream, module streams are named by the MBCS name as in GetModulesWithOffset
+		ms := p.ModuleStream.GetModule(string(m.NameRecord.ModuleName))
 		if ms == nil {
-			panic(fmt.Sprintf("Unknown module: %s", moduleName))
+			return nil, fmt.Errorf("%w: %q", ErrModuleNotFound, m.NameRecord.ModuleName)
 		}
 
 		vbaString := string(ms.SourceCode)
@@ -261,7 +274,7 @@ func contentNormalizedDataV3(p *VbaProject) []byte {
 			}
 		}
 		if hashModuleNameFlag { // IF HashModuleNameFlag IS true
-			if len(m.NameUnicodeRecord.ModuleNameUnicode) > 0 { // IF exist MODULE.NameUnicodeRecord.ModuleNameUnicode
+			if m.NameUnicodeRecord != nil && len(m.NameUnicodeRecord.ModuleNameUnicode) > 0 { // IF exist MODULE.NameUnicodeRecord.ModuleNameUnicode
 				// APPEND Buffer WITH MODULE.NameUnicodeRecord.ModuleNameUnicode (section 2.3.4.2.3.2.2)
 				buf = append(buf, m.NameUnicodeRecord.ModuleNameUnicode...) // variable
 			} else if len(m.NameRecord.ModuleName) > 0 { // ELSE IF exist MODULE.NameRecord.ModuleName
@@ -275,7 +288,7 @@ func contentNormalizedDataV3(p *VbaProject) []byte {
 	buf, _ = binary.Append(buf, binary.LittleEndian, int16(0x0010)) // MUST be 0x0010
 	// APPEND Buffer WITH Reserved (section 2.3.4.2) of Storage
 	buf, _ = binary.Append(buf, binary.LittleEndian, int32(0x00000000)) // MUST be 0x00000000
-	return buf
+	return buf, nil
 }
 
 func writeControlReference(buf []byte, ref *dirstream.ReferenceControl) []byte {
@@ -298,7 +311,7 @@ func writeControlReference(buf []byte, ref *dirstream.ReferenceControl) []byte {
 		buf = append(buf, ref.NameRecordExtended.Name...) // variable
 	}
 	// IF exists REFERENCE.ReferenceControl.NameRecordExtended.Reserved (section 2.3.4.2.2.2) THEN
-	if ref.NameRecordExtended.Reserved > 0 {
+	if ref.NameRecordExtended != nil && ref.NameRecordExtended.Reserved > 0 {
 		// APPEND Buffer WITH REFERENCE.ReferenceControl.NameRecordExtended.Reserved (section 2.3.4.2.2.2)
 		buf, _ = binary.Append(buf, binary.LittleEndian, int16(0x003e)) // MUST be 0x003e
 		// APPEND Buffer WITH REFERENCE.ReferenceControl.NameRecordExtended.SizeOfNameUnicode (section 2.3.4.2.2.2)
@@ -367,13 +380,16 @@ func writeProjectReference(buf []byte, ref *dirstream.ReferenceProject) []byte {
 }
 
 // 2.4.2.6 Project Normalized Data
-func projectNormalizedData(p *VbaProject) []byte {
+func projectNormalizedData(p *VbaProject) ([]byte, error) {
 	buf := bytes.Buffer{}
 	// FOR EACH property in ProjectProperties (section 2.3.1.1)
 	for _, prop := range p.ProjectStream.MainProperties {
 		if prop.Key == "BaseClass" { // IF property is ProjectDesignerModule THEN
 			// APPEND Buffer WITH output of NormalizeDesignerStorage(ProjectDesignerModule) (section 2.4.2.2)
 			mod := p.ModuleStream.GetModule(prop.Value)
+			if mod == nil {
+				return nil, fmt.Errorf("%w: designer module %q", ErrModuleNotFound, prop.Value)
+			}
 			buf.Write(normalizeDesignerStorage(mod))
 		}
 		// IF property NOT is ProjectId (section 2.3.1.2) OR ProjectDocModule (section 2.3.1.4)
@@ -396,7 +412,7 @@ func projectNormalizedData(p *VbaProject) []byte {
 			}
 		}
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 func parseModuleV3(text []byte) [][]byte {
@@ -0,0 +1,288 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/pkcs7"
+)
+
+// Request to sign a VBA project elsewhere (e.g. on an air-gapped machine holding the key), created by PrepareSigning.
+// The signature values of the signed attributes are attached to the unchanged document with AttachSignatures.
+type SigningRequest struct {
+	Version      int                `json:"version"`
+	Document     string             `json:"document"`     // hex encoded SHA-256 of the document, to detect changes before attaching
+	Certificates [][]byte           `json:"certificates"` // signing certificate followed by the issuing certificates (DER)
+	Signatures   []SignatureRequest `json:"signatures"`
+	SigningTime  time.Time          `json:"signingTime,omitzero"` // signing time of the signed attributes (SignOptions.SigningTime), if any
+}
+
+// Data to be signed for one signature version
+type SignatureRequest struct {
+	Signature        SignatureVersion `json:"signature"`
+	DigestAlgorithm  string           `json:"digestAlgorithm"`  // MD5 (V1) or SHA-256
+	Content          []byte           `json:"content"`          // SpcIndirectDataContent (GetContentInfo, GetContentInfoV2 or GetContentInfoV3)
+	SignedContent    []byte           `json:"signedContent"`    // content of the SpcIndirectDataContent without its SEQUENCE header, covered by the message digest (Authenticode)
+	SignedAttributes []byte           `json:"signedAttributes"` // DER encoded signed attributes, the data to be signed
+	Digest           []byte           `json:"digest"`           // digest of the signed attributes, for signers that sign digests only
+}
+
+// Signer returning a signature value created elsewhere, no signature while the request is prepared
+type externalSigner struct {
+	public    crypto.PublicKey
+	signature []byte
+}
+
+func (s *externalSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *externalSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return s.signature, nil
+}
+
+// Parses the VBA project of the document read from r (size bytes) and creates a request to sign it elsewhere,
+// for the versions included in the SignOptions. chain starts with the signing certificate, followed by the issuing
+// certificates added to the signatures. The private key is not needed.
+func PrepareSigning(r io.ReaderAt, size int64, chain []*x509.Certificate, so SignOptions) (*SigningRequest, error) {
+	if len(chain) == 0 || chain[0] == nil {
+		return nil, errors.New("signing request requires a signing certificate")
+	}
+	id, err := NewIdentity(&externalSigner{public: chain[0].PublicKey}, chain)
+	if err != nil {
+		return nil, err
+	}
+	if isStandaloneVbaProject(r, size) {
+		return nil, fmt.Errorf("%w: standalone vbaProject.bin, sign it with SignVbaProjectParts", ErrUnsupportedFormat)
+	}
+	p, err := ReadVbaProject(r, size, "")
+	if err != nil {
+		return nil, err
+	}
+	document, err := hashDocument(r, size)
+	if err != nil {
+		return nil, err
+	}
+	req := &SigningRequest{Version: 1, Document: document, SigningTime: so.SigningTime}
+	for _, cert := range append([]*x509.Certificate{id.Certificate}, id.CACerts...) {
+		req.Certificates = append(req.Certificates, cert.Raw)
+	}
+	for _, version := range so.versions() {
+		sr, err := newSignatureRequest(p, version, id, so.SigningTime)
+		if err != nil {
+			return nil, fmt.Errorf("%s signature: %w", version, err)
+		}
+		req.Signatures = append(req.Signatures, *sr)
+	}
+	if len(req.Signatures) == 0 {
+		return nil, errors.New("no signature version included in the sign options")
+	}
+	return req, nil
+}
+
+// The signed attributes are the same as when signing directly, they are taken from a signature without signature value
+func newSignatureRequest(p *VbaProject, version SignatureVersion, id *Identity, signingTime time.Time) (*SignatureRequest, error) {
+	content, err := getContentInfo(p, version)
+	if err != nil {
+		return nil, err
+	}
+	unsigned, err := getProjectSignature(p, version, id, signingTime)
+	if err != nil {
+		return nil, err
+	}
+	p7, err := pkcs7.Parse(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	signedAttributes, err := p7.SignedAttributes()
+	if err != nil {
+		return nil, err
+	}
+	hash, _ := version.digestAlgorithm()
+	h := hash.New()
+	h.Write(signedAttributes)
+	return &SignatureRequest{
+		Signature:        version,
+		DigestAlgorithm:  hash.String(),
+		Content:          content,
+		SignedContent:    p7.Content,
+		SignedAttributes: signedAttributes,
+		Digest:           h.Sum(nil),
+	}, nil
+}
+
+// Attaches the signatures created elsewhere for the request to the document read from r (size bytes) and writes the signed
+// document to w. signatures contains for each version of the request either the signature value of the signed attributes
+// (PKCS#1 v1.5 for RSA, ASN.1 for ECDSA) or a PKCS#7 signature (.p7s, may be detached) of SignedContent by the signing
+// certificate, with the content type SPC_INDIRECT_DATA (1.3.6.1.4.1.311.2.1.4) as signed attribute.
+// Returns ErrDocumentChanged if the document is not the one the request was prepared for.
+// Only the timestamp options of the SignOptions are used, the timestamps are requested when attaching.
+func AttachSignatures(w io.Writer, r io.ReaderAt, size int64, req *SigningRequest, signatures map[SignatureVersion][]byte, so SignOptions) error {
+	document, err := hashDocument(r, size)
+	if err != nil {
+		return err
+	}
+	if document != req.Document {
+		return fmt.Errorf("%w: SHA-256 %s, request prepared for %s", ErrDocumentChanged, document, req.Document)
+	}
+	chain, err := x509.ParseCertificates(bytes.Join(req.Certificates, nil))
+	if err != nil || len(chain) == 0 {
+		return fmt.Errorf("invalid certificates in signing request: %v", err)
+	}
+	so.IncludeV1, so.IncludeAgile, so.IncludeV3 = false, false, false
+	for _, sr := range req.Signatures {
+		switch sr.Signature {
+		case SignatureV1:
+			so.IncludeV1 = true
+		case SignatureAgile:
+			so.IncludeAgile = true
+		case SignatureV3:
+			so.IncludeV3 = true
+		}
+	}
+	id := &Identity{Certificate: chain[0], CACerts: chain[1:]}
+	_, _, err = writeSignedDocument(w, r, size, id.Certificate, so, func(p *VbaProject) ([]projectSignature, error) {
+		var res []projectSignature
+		for _, version := range so.versions() {
+			signatureBytes, err := assembleSignature(p, version, req, id, signatures[version])
+			if err != nil {
+				return nil, fmt.Errorf("%s signature: %w", version, err)
+			}
+			if signatureBytes, err = timestampSignature(signatureBytes, so); err != nil {
+				return nil, fmt.Errorf("%s timestamp: %w", version, err)
+			}
+			res = append(res, projectSignature{version: version, signature: signatureBytes})
+		}
+		return res, nil
+	})
+	return err
+}
+
+// Attaches the signatures to the Office file and writes the signed file as <name>-signed.<ext> next to it (see AttachSignatures)
+func AttachVbaProjectSignatures(officeFilePath string, req *SigningRequest, signatures map[SignatureVersion][]byte, so SignOptions) error {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return err
+	}
+	defer officeFile.Close()
+	_, err = createFileNextTo(officeFilePath, "signed", func(w io.Writer) error {
+		return AttachSignatures(w, officeFile, size, req, signatures, so)
+	})
+	return err
+}
+
+// Builds the signature (PKCS#7) from a signature value or a PKCS#7 signature created elsewhere and verifies it
+func assembleSignature(p *VbaProject, version SignatureVersion, req *SigningRequest, id *Identity, value []byte) ([]byte, error) {
+	sr := req.signature(version)
+	content, err := getContentInfo(p, version)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(content, sr.Content) {
+		return nil, fmt.Errorf("%w: content of the VBA project differs from the request", ErrDocumentChanged)
+	}
+	if len(value) == 0 {
+		return nil, errors.New("signature value missing")
+	}
+
+	// A PKCS#7 signature provides the signer, otherwise the value is used as signature of the signed attributes
+	detached, err := pkcs7.Parse(value)
+	signer := &externalSigner{public: id.Certificate.PublicKey}
+	if err != nil {
+		detached, signer.signature = nil, value
+	}
+	signatureBytes, err := getProjectSignature(p, version, &Identity{Certificate: id.Certificate, Signer: signer, CACerts: id.CACerts}, req.SigningTime)
+	if err != nil {
+		return nil, err
+	}
+	p7, err := pkcs7.Parse(signatureBytes)
+	if err != nil {
+		return nil, err
+	}
+	if detached != nil {
+		_, digestOid := version.digestAlgorithm()
+		if len(detached.Signers) != 1 || !detached.Signers[0].DigestAlgorithm.Algorithm.Equal(digestOid) {
+			return nil, fmt.Errorf("PKCS#7 signature must have exactly one signer using %s", sr.DigestAlgorithm)
+		}
+		// Signers of plain data (id-data) hash the same bytes, but Office requires the content type of the signed content
+		var contentType asn1.ObjectIdentifier
+		if err = detached.UnmarshalSignedAttribute(pkcs7.OIDAttributeContentType, &contentType); err != nil || !contentType.Equal(pkcs7.OIDDataMsCodeSigning) {
+			return nil, fmt.Errorf("PKCS#7 signature must have the content type SPC_INDIRECT_DATA (%s), not %s", pkcs7.OIDDataMsCodeSigning, contentType)
+		}
+		if err = p7.ReplaceSigners(detached); err != nil {
+			return nil, err
+		}
+		if signerCert := p7.GetOnlySigner(); signerCert == nil || !signerCert.Equal(id.Certificate) {
+			return nil, fmt.Errorf("PKCS#7 signature is not signed by %s", id.Certificate.Subject)
+		}
+		if signatureBytes, err = p7.Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	if err = p7.Verify(); err != nil {
+		return nil, fmt.Errorf("signature does not match the request: %w", err)
+	}
+	return signatureBytes, nil
+}
+
+// Returns the request of the given version, an empty request if not present
+func (req *SigningRequest) signature(version SignatureVersion) SignatureRequest {
+	for _, sr := range req.Signatures {
+		if sr.Signature == version {
+			return sr
+		}
+	}
+	return SignatureRequest{Signature: version}
+}
+
+func LoadSigningRequest(path string) (*SigningRequest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var req SigningRequest
+	if err = json.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("parsing signing request failed: %w", err)
+	}
+	return &req, nil
+}
+
+func (req *SigningRequest) Save(path string) error {
+	b, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Name of the signing request written next to a document
+func SigningRequestPath(officeFilePath string) string {
+	return officeFilePath + ".sigreq.json"
+}
+
+// Digest algorithm of the signature version: MD5 for V1, SHA-256 for Agile and V3
+func (v SignatureVersion) digestAlgorithm() (crypto.Hash, asn1.ObjectIdentifier) {
+	if v == SignatureV1 {
+		return crypto.MD5, pkcs7.OIDDigestAlgorithmMD5
+	}
+	return crypto.SHA256, pkcs7.OIDDigestAlgorithmSHA256
+}
+
+// Hex encoded SHA-256 of the document
+func hashDocument(r io.ReaderAt, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,135 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/opc"
+	"github.com/coffeeforyou/vbasig/pkcs7"
+)
+
+func TestSigningRequestSigningTime(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	signingTime := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	so := SignOptions{IncludeV3: true, SigningTime: signingTime}
+	req, err := PrepareSigning(bytes.NewReader(doc), int64(len(doc)), []*x509.Certificate{id.Certificate}, so)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signing time is part of the signed attributes and kept in the saved request
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded SigningRequest
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.SigningTime.Equal(signingTime) {
+		t.Fatalf("signing time %s in the loaded request, want %s", loaded.SigningTime, signingTime)
+	}
+	sr := loaded.Signatures[0]
+	value, err := id.Signer.Sign(rand.Reader, sr.Digest, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed bytes.Buffer
+	if err := AttachSignatures(&signed, bytes.NewReader(doc), int64(len(doc)), &loaded, map[SignatureVersion][]byte{SignatureV3: value}, SignOptions{IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing directly gives the same signature
+	var direct bytes.Buffer
+	if err := SignDocument(&direct, bytes.NewReader(doc), int64(len(doc)), "", id, so); err != nil {
+		t.Fatal(err)
+	}
+	var signatures [][]byte
+	for _, d := range [][]byte{signed.Bytes(), direct.Bytes()} {
+		results, err := VerifyDocument(bytes.NewReader(d), int64(len(d)), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !results[0].Valid {
+			t.Fatalf("verification %v, want one valid signature", results)
+		}
+		pkg, err := opc.Open(bytes.NewReader(d), int64(len(d)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, signature, err := readSignaturePart(pkg, "xl/vbaProject.bin", SignatureV3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, signature)
+	}
+	if !bytes.Equal(signatures[0], signatures[1]) {
+		t.Error("attached signature differs from the one signed directly")
+	}
+}
+
+// PKCS#7 signature of the signed content by another tool, detached
+func externalSignature(t *testing.T, id *Identity, sr SignatureRequest, contentType asn1.ObjectIdentifier) []byte {
+	t.Helper()
+	sd, err := pkcs7.NewSignedData(sr.SignedContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.GetSignedData().ContentInfo.ContentType = contentType
+	_, digestOid := sr.Signature.digestAlgorithm()
+	sd.SetDigestAlgorithm(digestOid)
+	if err := sd.AddSigner(id.Certificate, id.Signer, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestAttachPKCS7Signatures(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}
+	req, err := PrepareSigning(bytes.NewReader(doc), int64(len(doc)), []*x509.Certificate{id.Certificate}, so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures := map[SignatureVersion][]byte{}
+	for _, sr := range req.Signatures {
+		signatures[sr.Signature] = externalSignature(t, id, sr, pkcs7.OIDDataMsCodeSigning)
+	}
+	var signed bytes.Buffer
+	if err := AttachSignatures(&signed, bytes.NewReader(doc), int64(len(doc)), req, signatures, so); err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyDocument(bytes.NewReader(signed.Bytes()), int64(signed.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d signatures verified, want 3", len(results))
+	}
+	for i, r := range results {
+		if r.Version != SignatureVersions[i] || !r.Valid {
+			t.Errorf("%s", r)
+		}
+	}
+
+	// A signature of plain data has the wrong content type
+	signatures[SignatureV3] = externalSignature(t, id, req.Signatures[2], pkcs7.OIDData)
+	err = AttachSignatures(&bytes.Buffer{}, bytes.NewReader(doc), int64(len(doc)), req, signatures, so)
+	if err == nil || !strings.Contains(err.Error(), "SPC_INDIRECT_DATA") {
+		t.Errorf("got error %v for a signature of id-data, want content type error", err)
+	}
+}
@@ -0,0 +1,147 @@
+package vbaproject
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+// Signature parts of a standalone vbaProject.bin, to be added to the package next to vbaProject.bin by a later packaging step
+type SignatureParts struct {
+	Signatures   map[SignatureVersion][]byte // serialized signatures (DigSigInfoSerialized), named version.FileName()
+	Rels         []byte                      // relationships of vbaProject.bin to the signatures (_rels/vbaProject.bin.rels)
+	ContentTypes []byte                      // [Content_Types].xml with the overrides of the signature parts only, to be merged into the package
+}
+
+// Name of the file with the content types of the signature parts written by SignatureParts.Save
+const ContentTypesSnippetName = "vbaProject.bin.contenttypes.xml"
+
+const emptyContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+</Types>`
+
+// Signs a standalone vbaProject.bin read from r and returns the signature parts.
+// The part names in the content types are located in SignOptions.VbaFolder, "xl" if empty.
+func SignVbaProjectParts(r io.ReaderAt, id *Identity, so SignOptions) (*SignatureParts, error) {
+	sp, _, err := signVbaProjectParts(r, id, so)
+	return sp, err
+}
+
+func signVbaProjectParts(r io.ReaderAt, id *Identity, so SignOptions) (*SignatureParts, *VbaProject, error) {
+	if err := id.validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := so.checkDeterministic(id); err != nil {
+		return nil, nil, err
+	}
+	vbaProject, err := ParseVbaProject(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	signatures, err := createSignatures(vbaProject, id, so)
+	if err != nil {
+		return nil, nil, err
+	}
+	sp := &SignatureParts{Signatures: map[SignatureVersion][]byte{}}
+	for _, sig := range signatures {
+		sigInfo, err := vbasigfile.NewDigSigInfoSerialized(sig.signature, *id.Certificate)
+		if err != nil {
+			return nil, nil, err
+		}
+		sp.Signatures[sig.version] = sigInfo.Serialize()
+	}
+	if sp.Rels, err = AddRels([]byte(DefaultRels), so.vbaFolder(), so); err != nil {
+		return nil, nil, fmt.Errorf("creating relationships failed: %w", err)
+	}
+	if sp.ContentTypes, err = AddContentTypes([]byte(emptyContentTypes), so.vbaFolder(), so); err != nil {
+		return nil, nil, fmt.Errorf("creating content types failed: %w", err)
+	}
+	return sp, vbaProject, nil
+}
+
+// Writes the signature parts to a directory in the layout of the package: the signatures,
+// _rels/vbaProject.bin.rels and the content types (ContentTypesSnippetName). Signatures of versions not included
+// left by an earlier run are deleted. An existing _rels/vbaProject.bin.rels is edited in place like in a package,
+// other relationships are kept and those to the signatures deleted are removed.
+func (sp *SignatureParts) Save(dir string) error {
+	var versions []SignatureVersion
+	for _, version := range SignatureVersions {
+		name := filepath.Join(dir, version.FileName())
+		signatureFile, ok := sp.Signatures[version]
+		if !ok {
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := os.WriteFile(name, signatureFile, 0644); err != nil {
+			return err
+		}
+		versions = append(versions, version)
+	}
+
+	relsPath := filepath.Join(dir, "_rels", "vbaProject.bin.rels")
+	rels := sp.Rels
+	existing, err := os.ReadFile(relsPath)
+	switch {
+	case err == nil:
+		// The folder in the package is not known, the relationships are resolved relative to vbaProject.bin
+		if rels, _, err = updateRels(existing, "vbaProject.bin", signaturePartNames("."), versions); err != nil {
+			return fmt.Errorf("updating %s failed: %w", relsPath, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(relsPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(relsPath, rels, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ContentTypesSnippetName), sp.ContentTypes, 0644)
+}
+
+// Signs a standalone vbaProject.bin and writes the signature parts next to it (see SignatureParts.Save)
+func signStandaloneVbaProject(vbaProjectPath string, r io.ReaderAt, id *Identity, so SignOptions) (*SignReport, error) {
+	sp, vbaProject, err := signVbaProjectParts(r, id, so)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(vbaProjectPath)
+	report := &SignReport{}
+	for _, version := range SignatureVersions {
+		name := filepath.Join(dir, version.FileName())
+		_, err := os.Stat(name)
+		_, written := sp.Signatures[version]
+		report.add(name, err == nil, written)
+	}
+	if err = sp.Save(dir); err != nil {
+		return nil, err
+	}
+	if so.WriteManifest {
+		if err = NewManifest(vbaProject).Save(ManifestPath(vbaProjectPath)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// Reports whether r is a standalone vbaProject.bin, i.e. a compound file with the VBA project in the root storage
+func isStandaloneVbaProject(r io.ReaderAt, size int64) bool {
+	if !isCompoundFile(r) {
+		return false
+	}
+	root, err := cfb.Read(r, size)
+	return err == nil && isVbaProjectStorage(root)
+}
+
+// Reports whether the storage contains a VBA project (VBA storage and PROJECT stream)
+func isVbaProjectStorage(storage *cfb.Storage) bool {
+	return storage.Storage("VBA") != nil && storage.Stream("PROJECT") != nil
+}
@@ -0,0 +1,83 @@
+package vbaproject
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+func TestSignStandaloneVbaProject(t *testing.T) {
+	doc := testDocument(t)
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vbaProjectBin, err := pkg.ReadPart("xl/vbaProject.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	vbaProjectPath := filepath.Join(dir, "vbaProject.bin")
+	if err := os.WriteFile(vbaProjectPath, vbaProjectBin, 0644); err != nil {
+		t.Fatal(err)
+	}
+	id := testIdentity(t)
+
+	report, err := SignVbaProjectWithReport(vbaProjectPath, id, SignOptions{IncludeV1: true, IncludeV3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Added, []string{filepath.Join(dir, "vbaProjectSignature.bin"), filepath.Join(dir, "vbaProjectSignatureV3.bin")}) {
+		t.Errorf("got report\n%s", report)
+	}
+
+	// A relationship added by the packaging step is kept when signing again
+	relsPath := filepath.Join(dir, "_rels", "vbaProject.bin.rels")
+	rels, err := os.ReadFile(relsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := `<Relationship Id="other" Type="urn:other" Target="other.bin"/>`
+	rels = bytes.Replace(rels, []byte("</Relationships>"), []byte(other+"</Relationships>"), 1)
+	if err := os.WriteFile(relsPath, rels, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = SignVbaProjectWithReport(vbaProjectPath, id, SignOptions{IncludeV3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Replaced, []string{filepath.Join(dir, "vbaProjectSignatureV3.bin")}) ||
+		!slices.Equal(report.Removed, []string{filepath.Join(dir, "vbaProjectSignature.bin")}) || len(report.Added) != 0 {
+		t.Errorf("got report\n%s", report)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vbaProjectSignature.bin")); !os.IsNotExist(err) {
+		t.Errorf("stale V1 signature left: %v", err)
+	}
+	signatureFile, err := os.ReadFile(filepath.Join(dir, "vbaProjectSignatureV3.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseVbaProject(bytes.NewReader(vbaProjectBin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sv := VerifySignature(p, SignatureV3, signatureFile); !sv.Valid {
+		t.Error(sv)
+	}
+
+	rels, err = os.ReadFile(relsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(rels, []byte(other)) {
+		t.Errorf("relationship %s lost in %s", other, rels)
+	}
+	if bytes.Contains(rels, []byte(`"`+SignatureV1.RelationshipType()+`"`)) || !bytes.Contains(rels, []byte(`Type="`+SignatureV3.RelationshipType()+`" Target="vbaProjectSignatureV3.bin"`)) {
+		t.Errorf("got relationships %s, want the V3 relationship only", rels)
+	}
+}
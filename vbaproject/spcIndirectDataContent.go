@@ -2,6 +2,8 @@ package vbaproject
 
 import (
 	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
 )
 
 // Constants for known Object Identifiers
@@ -89,3 +91,53 @@ type SigDataV1SerializedHeader struct {
 	SourceHashOffset   int32
 	// algorithmId, compiledHash, sourceHash as []byte
 }
+
+// Parses the SpcIndirectDataContent from the content of a signature, which is given
+// without the outer SEQUENCE header (as returned by pkcs7.Parse)
+func ParseSpcIndirectDataContent(content []byte) (*SpcIndirectDataContent, error) {
+	seq, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		return nil, err
+	}
+	// Optional values are encoded without the explicit tag, therefore they are read as is
+	var raw struct {
+		Data struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue `asn1:"optional"`
+		}
+		MessageDigest struct {
+			DigestAlgorithm struct {
+				Algorithm  asn1.ObjectIdentifier
+				Parameters asn1.RawValue `asn1:"optional"`
+			}
+			Digest []byte
+		}
+	}
+	rest, err := asn1.Unmarshal(seq, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after SpcIndirectDataContent")
+	}
+	res := SpcIndirectDataContent{}
+	res.Data.Type = raw.Data.Type
+	res.Data.Value = raw.Data.Value
+	res.MessageDigest.DigestAlgorithm = AlgorithmIdentifier{Algorithm: raw.MessageDigest.DigestAlgorithm.Algorithm, Parameters: raw.MessageDigest.DigestAlgorithm.Parameters}
+	res.MessageDigest.Digest = raw.MessageDigest.Digest
+	return &res, nil
+}
+
+// Extracts the sourceHash from a SigDataV1Serialized structure
+func ParseSigDataV1SourceHash(data []byte) ([]byte, error) {
+	var header SigDataV1SerializedHeader
+	if _, err := binary.Decode(data, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("decoding SigDataV1Serialized failed: %w", err)
+	}
+	start := int64(header.SourceHashOffset)
+	end := start + int64(header.SourceHashSize)
+	if header.SourceHashSize < 0 || start < 0 || end > int64(len(data)) {
+		return nil, fmt.Errorf("sourceHash out of bounds: offset %d, size %d", header.SourceHashOffset, header.SourceHashSize)
+	}
+	return data[start:end], nil
+}
@@ -0,0 +1,143 @@
+package vbaproject
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/pkcs7"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+// Adds timestamps to the VBA signatures of a signed Office file without re-signing (the private key is not needed).
+// The timestamped file is written as <name>-timestamped.<ext> next to it.
+// Only TimestampURL and TimestampLegacy of the SignOptions are used.
+func TimestampVbaProject(officeFilePath string, so SignOptions) error {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return err
+	}
+	defer officeFile.Close()
+
+	_, err = createFileNextTo(officeFilePath, "timestamped", func(w io.Writer) error {
+		return TimestampDocument(w, officeFile, size, "", so)
+	})
+	return err
+}
+
+// Adds timestamps to the VBA signatures of a signed Office file read from r (size bytes) and writes the file to w.
+// The signature parts are replaced, all other parts are copied unchanged. The file type may be empty (see SignDocument).
+// In binary Office files (.xls, .doc) the signature streams are replaced and the compound file is rewritten.
+// Each signature is verified first, an invalid signature is not timestamped and fails the whole document.
+// Existing timestamps are replaced. Returns ErrNoSignature if the file contains no VBA signature.
+func TimestampDocument(w io.Writer, r io.ReaderAt, size int64, fileType string, so SignOptions) error {
+	if so.TimestampURL == "" {
+		return errors.New("timestamp URL required")
+	}
+	if err := checkFileType(r, fileType); err != nil {
+		return err
+	}
+	if isCompoundFile(r) {
+		return timestampCompoundDocument(w, r, size, so)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return err
+	}
+	vbaProject, vbaPart, err := readVbaProject(pkg)
+	if err != nil {
+		return err
+	}
+
+	// Timestamp each signature present
+	timestamped := 0
+	for _, version := range SignatureVersions {
+		partName, signatureBytes, err := readSignaturePart(pkg, vbaPart, version)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sigInfo, err := addSignatureTimestamps(vbaProject, version, partName, signatureBytes, vbasigfile.ParseDigSigInfoSerialized, so)
+		if err != nil {
+			return err
+		}
+		pkg.SetPart(partName, sigInfo.Serialize())
+		timestamped++
+	}
+	if timestamped == 0 {
+		return fmt.Errorf("%w next to %s", ErrNoSignature, vbaPart)
+	}
+
+	// Copy the package, replacing the signature parts
+	return pkg.Write(w, time.Time{})
+}
+
+// Adds timestamps to the signature streams of the VBA project storage of a binary Office file
+func timestampCompoundDocument(w io.Writer, r io.ReaderAt, size int64, so SignOptions) error {
+	root, err := cfb.Read(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	storage, err := findVbaStorage(root)
+	if err != nil {
+		return err
+	}
+	vbaProject, err := parseVbaStorage(storage)
+	if err != nil {
+		return err
+	}
+	parse := vbasigfile.ParseDigSigBlob
+	if isWordStorage(storage) {
+		parse = vbasigfile.ParseWordSigBlob
+	}
+	timestamped := 0
+	for _, version := range SignatureVersions {
+		stream := storage.Stream(version.StreamName())
+		if stream == nil {
+			continue
+		}
+		sigInfo, err := addSignatureTimestamps(vbaProject, version, signatureStreamPath(storage, version), stream.Data, parse, so)
+		if err != nil {
+			return err
+		}
+		blob := sigInfo.SerializeDigSigBlob()
+		if isWordStorage(storage) {
+			if blob, err = sigInfo.SerializeWordSigBlob(); err != nil {
+				return err
+			}
+		}
+		storage.SetStream(version.StreamName(), blob)
+		timestamped++
+	}
+	if timestamped == 0 {
+		return fmt.Errorf("%w in %s", ErrNoSignature, storage.Name)
+	}
+	_, err = root.WriteTo(w)
+	return err
+}
+
+// Verifies a signature and adds timestamps to it, parse extracts the DigSigInfoSerialized from the signature part or stream
+func addSignatureTimestamps(p *VbaProject, version SignatureVersion, name string, signature []byte, parse func([]byte) (*vbasigfile.DigSigInfoSerialized, error), so SignOptions) (*vbasigfile.DigSigInfoSerialized, error) {
+	// A timestamp would vouch for a signature that does not verify
+	if sv := verifyParsedSignature(p, version, signature, parse, VerifyOptions{}); !sv.Valid {
+		return nil, fmt.Errorf("%s: signature invalid, not timestamped: %s", name, sv.Reason)
+	}
+	sigInfo, err := parse(signature)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err = sigInfo.PbSignature.AddTimestamps(&pkcs7.TimestampClient{URL: so.TimestampURL, Legacy: so.TimestampLegacy}); err != nil {
+		return nil, fmt.Errorf("%s timestamp: %w", version, err)
+	}
+	pbSignature, err := sigInfo.PbSignature.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("%s timestamp: %w", version, err)
+	}
+	sigInfo.ReplaceSigBuffer(pbSignature)
+	return sigInfo, nil
+}
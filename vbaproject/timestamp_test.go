@@ -0,0 +1,216 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/internal/tsatest"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+func TestSignWithTimestamp(t *testing.T) {
+	at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	tsa := tsatest.New(t, tsatest.Config{Time: at})
+	id := testIdentity(t)
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true, TimestampURL: tsa.URL}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), ".xlsm", id, so); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := tsa.Roots()
+	roots.AddCert(id.Certificate)
+	// After the signing certificate has expired, the timestamp keeps the signatures valid
+	expired := id.Certificate.NotAfter.Add(time.Hour)
+	results, err := VerifyDocumentWithOptions(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm", VerifyOptions{Roots: roots, CurrentTime: expired})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d signatures verified, want 3", len(results))
+	}
+	for _, r := range results {
+		if !r.Valid || r.Timestamp == nil || !r.Expired {
+			t.Errorf("%s: valid %t, timestamp %v, expired %t", r.Version, r.Valid, r.Timestamp, r.Expired)
+			continue
+		}
+		if !r.Timestamp.Time.Equal(at) || r.Timestamp.Legacy {
+			t.Errorf("%s: timestamp %s (legacy %t), want RFC 3161 timestamp %s", r.Version, r.Timestamp.Time, r.Timestamp.Legacy, at)
+		}
+	}
+}
+
+func TestVerifyTimestampUntrustedTSA(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{})
+	id := testIdentity(t)
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV3: true, TimestampURL: tsa.URL}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), ".xlsm", id, so); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token of a TSA that is not trusted does not extend the validity of an expired signing certificate
+	signerRoots := x509.NewCertPool()
+	signerRoots.AddCert(id.Certificate)
+	expired := id.Certificate.NotAfter.Add(time.Hour)
+	for _, test := range []struct {
+		name   string
+		vo     VerifyOptions
+		reason string
+	}{
+		{"without roots", VerifyOptions{CurrentTime: expired}, "signing certificate not valid at"},
+		{"TSA not among the roots", VerifyOptions{Roots: signerRoots, CurrentTime: expired}, "timestamp invalid"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := VerifyDocumentWithOptions(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm", test.vo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("%d signatures verified, want 1", len(results))
+			}
+			if r := results[0]; r.Valid || r.Expired || !strings.Contains(r.Reason, test.reason) {
+				t.Errorf("valid %t, expired %t, reason %q, want invalid with %q", r.Valid, r.Expired, r.Reason, test.reason)
+			}
+		})
+	}
+
+	// Before the certificate expires the signature is valid without trusting the TSA
+	results, err := VerifyDocument(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Valid || results[0].Expired {
+		t.Errorf("verification %v, want one valid signature", results)
+	}
+}
+
+func TestSignWithRejectedTimestamp(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{Status: 2})
+	doc := testDocument(t)
+	so := SignOptions{IncludeV3: true, TimestampURL: tsa.URL}
+	if err := SignDocument(&bytes.Buffer{}, bytes.NewReader(doc), int64(len(doc)), ".xlsm", testIdentity(t), so); err == nil {
+		t.Fatal("signing succeeded although the TSA rejected the request")
+	}
+}
+
+func TestSignWithCountersignature(t *testing.T) {
+	at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+	tsa := tsatest.New(t, tsatest.Config{Time: at})
+	id := testIdentity(t)
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true, TimestampURL: tsa.URL, TimestampLegacy: true}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), ".xlsm", id, so); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := tsa.Roots()
+	roots.AddCert(id.Certificate)
+	results, err := VerifyDocumentWithOptions(bytes.NewReader(signed.Bytes()), int64(signed.Len()), ".xlsm", VerifyOptions{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d signatures verified, want 3", len(results))
+	}
+	for _, r := range results {
+		if !r.Valid || r.Timestamp == nil || !r.Timestamp.Legacy || !r.Timestamp.Time.Equal(at) {
+			t.Errorf("%s: valid %t, timestamp %v, want legacy timestamp %s", r.Version, r.Valid, r.Timestamp, at)
+		}
+	}
+}
+
+func TestTimestampDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  []byte
+	}{
+		{"xlsm", testDocument(t)},
+		{"xls", testCompoundDocument(t, "_VBA_PROJECT_CUR", "Workbook")},
+		{"doc", testCompoundDocument(t, "Macros", "WordDocument")},
+	}
+	id := testIdentity(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			at := time.Now().UTC().Truncate(time.Second).Add(-time.Minute)
+			tsa := tsatest.New(t, tsatest.Config{Time: at})
+			var signed, timestamped bytes.Buffer
+			so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}
+			if err := SignDocument(&signed, bytes.NewReader(test.doc), int64(len(test.doc)), "", id, so); err != nil {
+				t.Fatal(err)
+			}
+			if err := TimestampDocument(&timestamped, bytes.NewReader(signed.Bytes()), int64(signed.Len()), "", SignOptions{TimestampURL: tsa.URL}); err != nil {
+				t.Fatal(err)
+			}
+			roots := tsa.Roots()
+			roots.AddCert(id.Certificate)
+			results, err := VerifyDocumentWithOptions(bytes.NewReader(timestamped.Bytes()), int64(timestamped.Len()), "", VerifyOptions{Roots: roots})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 3 {
+				t.Fatalf("%d signatures verified, want 3", len(results))
+			}
+			for _, r := range results {
+				if !r.Valid || r.Timestamp == nil || !r.Timestamp.Time.Equal(at) {
+					t.Errorf("%s: valid %t, timestamp %v, want timestamp %s", r, r.Valid, r.Timestamp, at)
+				}
+			}
+		})
+	}
+}
+
+func TestTimestampDocumentInvalidSignature(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{})
+	doc := testCompoundDocument(t, "_VBA_PROJECT_CUR", "Workbook")
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), SignOptions{IncludeAgile: true, IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the RSA signature of the V3 signature stream, the Agile signature stays valid
+	root, err := cfb.Read(bytes.NewReader(signed.Bytes()), int64(signed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := root.Storage("_VBA_PROJECT_CUR")
+	stream := storage.Stream(SignatureV3.StreamName())
+	sigInfo, err := vbasigfile.ParseDigSigBlob(stream.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigInfo.PbSignature.Signers[0].EncryptedDigest[0] ^= 0xff
+	pbSignature, err := sigInfo.PbSignature.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigInfo.ReplaceSigBuffer(pbSignature)
+	storage.SetStream(SignatureV3.StreamName(), sigInfo.SerializeDigSigBlob())
+	var broken bytes.Buffer
+	if _, err := root.WriteTo(&broken); err != nil {
+		t.Fatal(err)
+	}
+
+	err = TimestampDocument(&bytes.Buffer{}, bytes.NewReader(broken.Bytes()), int64(broken.Len()), "", SignOptions{TimestampURL: tsa.URL})
+	if err == nil || !strings.Contains(err.Error(), "signature invalid, not timestamped") {
+		t.Fatalf("got error %v, want invalid signature", err)
+	}
+}
+
+func TestTimestampDocumentUnsigned(t *testing.T) {
+	tsa := tsatest.New(t, tsatest.Config{})
+	for _, doc := range [][]byte{testDocument(t), testCompoundDocument(t, "Macros", "WordDocument")} {
+		err := TimestampDocument(&bytes.Buffer{}, bytes.NewReader(doc), int64(len(doc)), "", SignOptions{TimestampURL: tsa.URL})
+		if !errors.Is(err, ErrNoSignature) {
+			t.Errorf("got error %v, want ErrNoSignature", err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package vbaproject
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/cfb"
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+// Removes the VBA signatures of a signed Office file and writes the unsigned file as <name>-unsigned.<ext> next to it
+func UnsignVbaProject(officeFilePath string) error {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return err
+	}
+	defer officeFile.Close()
+
+	_, err = createFileNextTo(officeFilePath, "unsigned", func(w io.Writer) error {
+		return UnsignDocument(w, officeFile, size)
+	})
+	return err
+}
+
+// Removes the VBA signatures of an Office file read from r (size bytes) and writes the file to w.
+// The signature parts are the targets of the signature relationships of the VBA project, wherever they are stored,
+// and the parts with the conventional names next to the VBA project part (vbaProjectSignature*.bin), also without
+// relationship. They are deleted together with these relationships and their content type overrides, all other parts
+// are copied byte-identical. The relationships part of the VBA project is deleted if no relationship is left.
+// In binary Office files (.xls, .doc) the signature streams are removed and the compound file is rewritten.
+// Returns ErrNoSignature if the VBA project has neither signature relationships nor signature parts.
+func UnsignDocument(w io.Writer, r io.ReaderAt, size int64) error {
+	if isCompoundFile(r) {
+		return unsignCompoundDocument(w, r, size)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return err
+	}
+	vbaPart, err := findVbaProjectPart(pkg)
+	if err != nil {
+		return err
+	}
+
+	// The signature parts are the internal targets of the relationships removed
+	var targets []string
+	removed := 0
+	err = editRelationships(pkg, vbaPart, func(part *xmlPart) {
+		for _, rel := range part.elements("Relationship") {
+			if !slices.ContainsFunc(SignatureVersions, func(v SignatureVersion) bool { return rel.attrs["Type"] == v.RelationshipType() }) {
+				continue
+			}
+			part.remove(rel)
+			removed++
+			if !strings.EqualFold(rel.attrs["TargetMode"], "External") {
+				targets = append(targets, opc.ResolveTarget(vbaPart, rel.attrs["Target"]))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, version := range SignatureVersions {
+		if name := signaturePartName(vbaPart, version); pkg.Part(name) != nil && !slices.Contains(targets, name) {
+			targets = append(targets, name)
+		}
+	}
+	if removed == 0 && !slices.ContainsFunc(targets, func(name string) bool { return pkg.Part(name) != nil }) {
+		return fmt.Errorf("%w next to %s", ErrNoSignature, vbaPart)
+	}
+	for _, target := range targets {
+		pkg.RemovePart(target)
+	}
+	if pkg.Part(opc.ContentTypesName) != nil {
+		err = editContentTypes(pkg, func(part *xmlPart) {
+			for _, target := range targets {
+				removeOverrides(part, target)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Copy the package in the original order, without the removed parts
+	return pkg.Write(w, time.Time{})
+}
+
+// Removes the signature streams of the VBA project storage of a binary Office file
+func unsignCompoundDocument(w io.Writer, r io.ReaderAt, size int64) error {
+	root, err := cfb.Read(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	storage, err := findVbaStorage(root)
+	if err != nil {
+		return err
+	}
+	removed := false
+	for _, version := range SignatureVersions {
+		if storage.Stream(version.StreamName()) != nil {
+			storage.RemoveStream(version.StreamName())
+			removed = true
+		}
+	}
+	if !removed {
+		return fmt.Errorf("%w in %s", ErrNoSignature, storage.Name)
+	}
+	_, err = root.WriteTo(w)
+	return err
+}
@@ -0,0 +1,148 @@
+package vbaproject
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+func TestUnsignDocument(t *testing.T) {
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), so); err != nil {
+		t.Fatal(err)
+	}
+
+	// The V3 signature is moved to another folder, it is found through its relationship
+	pkg, err := opc.Open(bytes.NewReader(signed.Bytes()), int64(signed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, replace := range map[string][2]string{
+		"xl/_rels/vbaProject.bin.rels": {`Target="vbaProjectSignatureV3.bin"`, `Target="signatures/v3.bin"`},
+		opc.ContentTypesName:           {`PartName="/xl/vbaProjectSignatureV3.bin"`, `PartName="/xl/signatures/v3.bin"`},
+	} {
+		data, err := pkg.ReadPart(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte(replace[0])) {
+			t.Fatalf("%s does not contain %s", name, replace[0])
+		}
+		pkg.SetPart(name, bytes.Replace(data, []byte(replace[0]), []byte(replace[1]), 1))
+	}
+	v3, err := pkg.ReadPart("xl/vbaProjectSignatureV3.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.RemovePart("xl/vbaProjectSignatureV3.bin")
+	pkg.SetPart("xl/signatures/v3.bin", v3)
+	var moved bytes.Buffer
+	if err := pkg.Write(&moved, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyDocument(bytes.NewReader(moved.Bytes()), int64(moved.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[2].Version != SignatureV3 || results[2].PartName != "xl/signatures/v3.bin" || !results[2].Valid {
+		t.Fatalf("got %v, want the moved V3 signature found and valid", results)
+	}
+
+	// Signing again replaces the moved signature where it is
+	var resigned bytes.Buffer
+	if err := SignDocument(&resigned, bytes.NewReader(moved.Bytes()), int64(moved.Len()), "", testIdentity(t), so); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err = opc.Open(bytes.NewReader(resigned.Bytes()), int64(resigned.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Part("xl/signatures/v3.bin") == nil || pkg.Part("xl/vbaProjectSignatureV3.bin") != nil {
+		t.Error("re-signing did not replace the moved V3 signature in place")
+	}
+
+	var unsigned bytes.Buffer
+	if err := UnsignDocument(&unsigned, bytes.NewReader(moved.Bytes()), int64(moved.Len())); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err = opc.Open(bytes.NewReader(unsigned.Bytes()), int64(unsigned.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pkg.Parts() {
+		if strings.Contains(p.Name, "Signature") || strings.HasPrefix(p.Name, "xl/signatures/") {
+			t.Errorf("part %s left", p.Name)
+		}
+	}
+	contentTypes, err := pkg.ReadPart(opc.ContentTypesName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contentTypes, []byte("signature")) || bytes.Contains(contentTypes, []byte("Signature")) {
+		t.Errorf("overrides of the signatures left in %s", contentTypes)
+	}
+	if err := UnsignDocument(&bytes.Buffer{}, bytes.NewReader(unsigned.Bytes()), int64(unsigned.Len())); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("got error %v for an unsigned document, want ErrNoSignature", err)
+	}
+}
+
+func TestUnsignDocumentWithoutRelationships(t *testing.T) {
+	doc := testDocument(t)
+	var signed bytes.Buffer
+	so := SignOptions{IncludeV1: true, IncludeV3: true}
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", testIdentity(t), so); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signature parts with the conventional names are found without relationships
+	pkg, err := opc.Open(bytes.NewReader(signed.Bytes()), int64(signed.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.RemovePart("xl/_rels/vbaProject.bin.rels")
+	var orphaned bytes.Buffer
+	if err := pkg.Write(&orphaned, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyDocument(bytes.NewReader(orphaned.Bytes()), int64(orphaned.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := 0
+	for _, r := range results {
+		if r.Valid {
+			valid++
+		}
+	}
+	if valid != 2 {
+		t.Errorf("got %v, want valid V1 and V3 signatures", results)
+	}
+
+	var unsigned bytes.Buffer
+	if err := UnsignDocument(&unsigned, bytes.NewReader(orphaned.Bytes()), int64(orphaned.Len())); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err = opc.Open(bytes.NewReader(unsigned.Bytes()), int64(unsigned.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"xl/vbaProjectSignature.bin", "xl/vbaProjectSignatureV3.bin"} {
+		if pkg.Part(name) != nil {
+			t.Errorf("part %s left", name)
+		}
+	}
+	contentTypes, err := pkg.ReadPart(opc.ContentTypesName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contentTypes, []byte("vbaProjectSignature")) {
+		t.Errorf("overrides of the signatures left in %s", contentTypes)
+	}
+}
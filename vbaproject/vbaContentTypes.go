@@ -1,63 +1,54 @@
 package vbaproject
 
 import (
-	"encoding/xml"
-	"fmt"
-)
+	"slices"
+	"strings"
 
-type Types struct {
-	XMLName   xml.Name   `xml:"http://schemas.openxmlformats.org/package/2006/content-types Types"`
-	Defaults  []Default  `xml:"Default"`
-	Overrides []Override `xml:"Override"`
-}
+	"github.com/coffeeforyou/vbasig/opc"
+)
 
-type Default struct {
-	Extension   string `xml:"Extension,attr"`
-	ContentType string `xml:"ContentType,attr"`
-}
+// Content types part, see package opc
+type (
+	Types    = opc.Types
+	Default  = opc.Default
+	Override = opc.Override
+)
 
-type Override struct {
-	PartName    string `xml:"PartName,attr"`
-	ContentType string `xml:"ContentType,attr"`
+// Adds the overrides of the signature parts in the given folder (the folder of the VBA project part, e.g. "xl")
+// for the versions included in the SignOptions and removes those of the other versions.
+// The XML is edited in place, existing defaults and overrides are kept as they are.
+func AddContentTypes(xmlData []byte, folder string, so SignOptions) ([]byte, error) {
+	output, _, err := updateContentTypes(xmlData, signaturePartNames(folder), so.versions())
+	return output, err
 }
 
-func AddContentTypes(xmlData []byte, path string, so SignOptions) ([]byte, error) {
-	// Step 1: Unmarshal XML to struct
-	var types Types
-	if err := xml.Unmarshal([]byte(xmlData), &types); err != nil {
-		return nil, err
-	}
-
-	// Step 2: Check the overrides for VBA are present
-	if so.IncludeV1 {
-		types.updateOverrides(fmt.Sprintf("/%s/vbaProjectSignature.bin", path), "application/vnd.ms-office.vbaProjectSignature")
-	}
-	if so.IncludeAgile {
-		types.updateOverrides(fmt.Sprintf("/%s/vbaProjectSignatureAgile.bin", path), "application/vnd.ms-office.vbaProjectSignatureAgile")
-	}
-	if so.IncludeV3 {
-		types.updateOverrides(fmt.Sprintf("/%s/vbaProjectSignatureV3.bin", path), "application/vnd.ms-office.vbaProjectSignatureV3")
-	}
-
-	// Step 3: Marshal struct back to XML
-	output, err := xml.MarshalIndent(types, "", "  ")
+// Updates the overrides of the signature parts (partNames, by version), returns the versions whose overrides were removed
+func updateContentTypes(xmlData []byte, partNames map[SignatureVersion]string, versions []SignatureVersion) ([]byte, []SignatureVersion, error) {
+	part, err := parseXMLPart(xmlData, "Types")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	// Step 4: Get header back
-	output = append([]byte(xml.Header), output...)
-	return output, nil
-}
-
-func (t *Types) updateOverrides(partName string, contentType string) {
-	for _, o := range t.Overrides {
-		if o.PartName == partName {
-			return
+	overrides := part.elements("Override")
+
+	// Overrides of signatures not written would dangle
+	var removed []SignatureVersion
+	for _, version := range SignatureVersions {
+		partName := "/" + partNames[version]
+		n := 0
+		for _, o := range overrides {
+			if strings.EqualFold(o.attrs["PartName"], partName) {
+				if !slices.Contains(versions, version) {
+					part.remove(o)
+				}
+				n++
+			}
+		}
+		switch {
+		case !slices.Contains(versions, version) && n > 0:
+			removed = append(removed, version)
+		case slices.Contains(versions, version) && n == 0:
+			part.insert("Override", [2]string{"PartName", partName}, [2]string{"ContentType", version.ContentType()})
 		}
 	}
-	t.Overrides = append(t.Overrides, Override{
-		PartName:    partName,
-		ContentType: contentType,
-	})
+	return part.bytes(), removed, nil
 }
@@ -0,0 +1,104 @@
+package vbaproject
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+func signatureOverride(partName string, version SignatureVersion) string {
+	return fmt.Sprintf(`<Override PartName="%s" ContentType="%s"/>`, partName, version.ContentType())
+}
+
+func TestUpdateContentTypes(t *testing.T) {
+	// [Content_Types].xml of an unsigned workbook saved by Excel
+	doc := testDocument(t)
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkg.ReadPart(opc.ContentTypesName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	office := string(data)
+	if !strings.HasPrefix(office, officeDeclaration) || !strings.HasSuffix(office, "</Types>") {
+		t.Fatalf("unexpected %s of the test document: %q", opc.ContentTypesName, office)
+	}
+	signed := strings.TrimSuffix(office, "</Types>") + signatureOverride("/xl/vbaProjectSignature.bin", SignatureV1) +
+		signatureOverride("/xl/vbaProjectSignatureAgile.bin", SignatureAgile) + signatureOverride("/xl/vbaProjectSignatureV3.bin", SignatureV3) + "</Types>"
+	typesNamespace := `xmlns="http://schemas.openxmlformats.org/package/2006/content-types"`
+	all := []SignatureVersion{SignatureV1, SignatureAgile, SignatureV3}
+	tests := []struct {
+		name     string
+		input    string
+		folder   string
+		versions []SignatureVersion
+		want     string
+		removed  []SignatureVersion
+	}{
+		{
+			name:     "saved by Excel, all versions added",
+			input:    office,
+			folder:   "xl",
+			versions: all,
+			want:     signed,
+		},
+		{
+			name:     "unchanged",
+			input:    signed,
+			folder:   "xl",
+			versions: all,
+			want:     signed,
+		},
+		{
+			name:     "V3 only",
+			input:    signed,
+			folder:   "xl",
+			versions: []SignatureVersion{SignatureV3},
+			want:     strings.TrimSuffix(office, "</Types>") + signatureOverride("/xl/vbaProjectSignatureV3.bin", SignatureV3) + "</Types>",
+			removed:  []SignatureVersion{SignatureV1, SignatureAgile},
+		},
+		{
+			name:     "part names compared case-insensitive",
+			input:    "<Types " + typesNamespace + ">" + signatureOverride("/XL/VbaProjectSignature.bin", SignatureV1) + "</Types>",
+			folder:   "xl",
+			versions: nil,
+			want:     "<Types " + typesNamespace + "></Types>",
+			removed:  []SignatureVersion{SignatureV1},
+		},
+		{
+			name:     "self-closing root element",
+			input:    officeDeclaration + "<Types " + typesNamespace + "/>",
+			folder:   "word",
+			versions: []SignatureVersion{SignatureV3},
+			want:     officeDeclaration + "<Types " + typesNamespace + ">" + signatureOverride("/word/vbaProjectSignatureV3.bin", SignatureV3) + "</Types>",
+		},
+		{
+			name:     "indented with CRLF and byte order mark",
+			input:    "\xEF\xBB\xBF" + officeDeclaration + "<Types " + typesNamespace + ">\r\n\t" + `<Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` + "\r\n</Types>",
+			folder:   "ppt",
+			versions: []SignatureVersion{SignatureV1, SignatureV3},
+			want: "\xEF\xBB\xBF" + officeDeclaration + "<Types " + typesNamespace + ">\r\n\t" + `<Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` +
+				"\r\n\t" + signatureOverride("/ppt/vbaProjectSignature.bin", SignatureV1) + "\r\n\t" + signatureOverride("/ppt/vbaProjectSignatureV3.bin", SignatureV3) + "\r\n</Types>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, removed, err := updateContentTypes([]byte(test.input), signaturePartNames(test.folder), test.versions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+			if !slices.Equal(removed, test.removed) {
+				t.Errorf("removed %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
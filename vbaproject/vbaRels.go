@@ -1,65 +1,85 @@
 package vbaproject
 
 import (
-	"encoding/xml"
 	"fmt"
-)
+	"path"
+	"slices"
+	"strings"
 
-type Relationships struct {
-	XMLName       xml.Name        `xml:"http://schemas.openxmlformats.org/package/2006/relationships Relationships"`
-	Relationships []*Relationship `xml:"Relationship"`
-}
+	"github.com/coffeeforyou/vbasig/opc"
+)
 
-type Relationship struct {
-	ID     string `xml:"Id,attr"`
-	Type   string `xml:"Type,attr"`
-	Target string `xml:"Target,attr"`
-}
+// Relationships part, see package opc
+type (
+	Relationships = opc.Relationships
+	Relationship  = opc.Relationship
+)
 
+// Adds the relationships to the signature parts of the versions included in the SignOptions and removes those of the other versions.
+// path is the folder of the VBA project part. The XML is edited in place, existing relationships and their IDs are kept.
 func AddRels(xmlData []byte, path string, so SignOptions) ([]byte, error) {
-	// Step 1: Unmarshal XML to struct
-	var relationships Relationships
-	if err := xml.Unmarshal([]byte(xmlData), &relationships); err != nil {
-		return nil, err
-	}
+	output, _, err := updateRels(xmlData, path+"/vbaProject.bin", signaturePartNames(path), so.versions())
+	return output, err
+}
 
-	// Step 2: Check the elements for VBA are present
-	if so.IncludeV1 {
-		relationships.updateRelationship("vbaProjectSignature.bin", "http://schemas.microsoft.com/office/2006/relationships/vbaProjectSignature")
-	}
-	if so.IncludeAgile {
-		relationships.updateRelationship("vbaProjectSignatureAgile.bin", "http://schemas.microsoft.com/office/2014/relationships/vbaProjectSignatureAgile")
+// Updates the relationships from the VBA project part (source) to the signature parts (partNames, by version),
+// returns the versions whose relationships were removed. Relationships of an included version to another part are
+// removed, they would dangle or point to a stale signature.
+func updateRels(xmlData []byte, source string, partNames map[SignatureVersion]string, versions []SignatureVersion) ([]byte, []SignatureVersion, error) {
+	part, err := parseXMLPart(xmlData, "Relationships")
+	if err != nil {
+		return nil, nil, err
 	}
-	if so.IncludeV3 {
-		relationships.updateRelationship("vbaProjectSignatureV3.bin", "http://schemas.microsoft.com/office/2020/07/relationships/vbaProjectSignatureV3")
+	relationships := part.elements("Relationship")
+	ids := map[string]bool{}
+	for _, r := range relationships {
+		ids[r.attrs["Id"]] = true
 	}
 
-	// Step 3: Renumber items to ensure unique ids
-	for i, r := range relationships.Relationships {
-		r.ID = fmt.Sprintf("rId%d", i+1)
+	var removed []SignatureVersion
+	for _, version := range SignatureVersions {
+		included := slices.Contains(versions, version)
+		n, present := 0, false
+		for _, r := range relationships {
+			if r.attrs["Type"] != version.RelationshipType() {
+				continue
+			}
+			if included && !present && strings.EqualFold(opc.ResolveTarget(source, r.attrs["Target"]), partNames[version]) {
+				present = true
+				continue
+			}
+			part.remove(r)
+			n++
+		}
+		if !included && n > 0 {
+			// Relationships to signatures not written would dangle
+			removed = append(removed, version)
+		}
+		if included && !present {
+			id := newRelationshipID(ids)
+			part.insert("Relationship", [2]string{"Id", id}, [2]string{"Type", version.RelationshipType()}, [2]string{"Target", relativeTarget(source, partNames[version])})
+		}
 	}
+	return part.bytes(), removed, nil
+}
 
-	// Step 3: Marshal struct back to XML
-	output, err := xml.MarshalIndent(relationships, "", "  ")
-	if err != nil {
-		return nil, err
+// Target of a relationship from source to the part, relative if the part is in the folder of source
+func relativeTarget(source string, partName string) string {
+	if path.Dir(partName) == path.Dir(source) {
+		return path.Base(partName)
 	}
-
-	// Step 4: Get header back
-	output = append([]byte(xml.Header), output...)
-	return output, nil
+	return "/" + partName
 }
 
-func (t *Relationships) updateRelationship(target string, relType string) {
-	for _, r := range t.Relationships {
-		if r.Target == target {
-			return
+// Returns the first free ID of the form rId<n> and marks it as used
+func newRelationshipID(ids map[string]bool) string {
+	for i := 1; ; i++ {
+		id := fmt.Sprintf("rId%d", i)
+		if !ids[id] {
+			ids[id] = true
+			return id
 		}
 	}
-	t.Relationships = append(t.Relationships, &Relationship{
-		Target: target,
-		Type:   relType,
-	})
 }
 
 const DefaultRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
@@ -0,0 +1,151 @@
+package vbaproject
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// Declaration as written by Office, followed by CRLF
+const officeDeclaration = "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\r\n"
+
+const relationshipsNamespace = `xmlns="http://schemas.openxmlformats.org/package/2006/relationships"`
+
+func signatureRelationship(id int, version SignatureVersion) string {
+	return fmt.Sprintf(`<Relationship Id="rId%d" Type="%s" Target="%s"/>`, id, version.RelationshipType(), version.FileName())
+}
+
+func TestUpdateRels(t *testing.T) {
+	// xl/_rels/vbaProject.bin.rels of a workbook signed by Office with all signature versions
+	office := officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV1) +
+		signatureRelationship(2, SignatureAgile) + signatureRelationship(3, SignatureV3) + "</Relationships>"
+	all := []SignatureVersion{SignatureV1, SignatureAgile, SignatureV3}
+	tests := []struct {
+		name     string
+		input    string
+		versions []SignatureVersion
+		parts    map[SignatureVersion]string // default: next to the VBA project part
+		want     string
+		removed  []SignatureVersion
+	}{
+		{
+			name:     "unchanged",
+			input:    office,
+			versions: all,
+			want:     office,
+		},
+		{
+			name:     "signed by Office, V3 only",
+			input:    office,
+			versions: []SignatureVersion{SignatureV3},
+			want:     officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(3, SignatureV3) + "</Relationships>",
+			removed:  []SignatureVersion{SignatureV1, SignatureAgile},
+		},
+		{
+			name:     "signed by Office with V1 only, V3 added",
+			input:    officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV1) + "</Relationships>",
+			versions: []SignatureVersion{SignatureV1, SignatureV3},
+			want: officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV1) +
+				signatureRelationship(2, SignatureV3) + "</Relationships>",
+		},
+		{
+			name:     "self-closing root element",
+			input:    officeDeclaration + "<Relationships " + relationshipsNamespace + "/>",
+			versions: []SignatureVersion{SignatureV3},
+			want:     officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV3) + "</Relationships>",
+		},
+		{
+			name: "gaps in the IDs are filled",
+			input: officeDeclaration + "<Relationships " + relationshipsNamespace + `><Relationship Id="rId1" Type="urn:other" Target="a.xml"/>` +
+				`<Relationship Id="rId3" Type="urn:other" Target="b.xml"/></Relationships>`,
+			versions: all,
+			want: officeDeclaration + "<Relationships " + relationshipsNamespace + `><Relationship Id="rId1" Type="urn:other" Target="a.xml"/>` +
+				`<Relationship Id="rId3" Type="urn:other" Target="b.xml"/>` + signatureRelationship(2, SignatureV1) +
+				signatureRelationship(4, SignatureAgile) + signatureRelationship(5, SignatureV3) + "</Relationships>",
+		},
+		{
+			name:     "absolute target",
+			input:    "<Relationships " + relationshipsNamespace + `><Relationship Id="sig" Type="` + SignatureV3.RelationshipType() + `" Target="/xl/vbaProjectSignatureV3.bin"/></Relationships>`,
+			versions: []SignatureVersion{SignatureV3},
+			want:     "<Relationships " + relationshipsNamespace + `><Relationship Id="sig" Type="` + SignatureV3.RelationshipType() + `" Target="/xl/vbaProjectSignatureV3.bin"/></Relationships>`,
+		},
+		{
+			name:     "indented with CRLF",
+			input:    officeDeclaration + "<Relationships " + relationshipsNamespace + ">\r\n  " + signatureRelationship(1, SignatureV1) + "\r\n  " + signatureRelationship(2, SignatureAgile) + "\r\n</Relationships>\r\n",
+			versions: []SignatureVersion{SignatureV1, SignatureV3},
+			want:     officeDeclaration + "<Relationships " + relationshipsNamespace + ">\r\n  " + signatureRelationship(1, SignatureV1) + "\r\n  " + signatureRelationship(3, SignatureV3) + "\r\n</Relationships>\r\n",
+			removed:  []SignatureVersion{SignatureAgile},
+		},
+		{
+			name:     "byte order mark",
+			input:    "\xEF\xBB\xBF" + officeDeclaration + "<Relationships " + relationshipsNamespace + "></Relationships>",
+			versions: []SignatureVersion{SignatureV3},
+			want:     "\xEF\xBB\xBF" + officeDeclaration + "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV3) + "</Relationships>",
+		},
+		{
+			name:     "namespace prefix",
+			input:    `<r:Relationships xmlns:r="http://schemas.openxmlformats.org/package/2006/relationships"></r:Relationships>`,
+			versions: []SignatureVersion{SignatureV3},
+			want:     `<r:Relationships xmlns:r="http://schemas.openxmlformats.org/package/2006/relationships"><r:` + signatureRelationship(1, SignatureV3)[1:] + `</r:Relationships>`,
+		},
+		{
+			name:     "part in another folder",
+			input:    "<Relationships " + relationshipsNamespace + `><Relationship Id="sig" Type="` + SignatureV3.RelationshipType() + `" Target="signatures/v3.bin"/></Relationships>`,
+			versions: []SignatureVersion{SignatureV3},
+			parts:    map[SignatureVersion]string{SignatureV3: "xl/signatures/v3.bin"},
+			want:     "<Relationships " + relationshipsNamespace + `><Relationship Id="sig" Type="` + SignatureV3.RelationshipType() + `" Target="signatures/v3.bin"/></Relationships>`,
+		},
+		{
+			name:     "new part in another folder",
+			input:    "<Relationships " + relationshipsNamespace + "></Relationships>",
+			versions: []SignatureVersion{SignatureV3},
+			parts:    map[SignatureVersion]string{SignatureV3: "signatures/v3.bin"},
+			want:     "<Relationships " + relationshipsNamespace + `><Relationship Id="rId1" Type="` + SignatureV3.RelationshipType() + `" Target="/signatures/v3.bin"/></Relationships>`,
+		},
+		{
+			name:     "relationship to another part is replaced",
+			input:    "<Relationships " + relationshipsNamespace + `><Relationship Id="sig" Type="` + SignatureV3.RelationshipType() + `" Target="old.bin"/></Relationships>`,
+			versions: []SignatureVersion{SignatureV3},
+			want:     "<Relationships " + relationshipsNamespace + ">" + signatureRelationship(1, SignatureV3) + "</Relationships>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := test.parts
+			if parts == nil {
+				parts = signaturePartNames("xl")
+			}
+			got, removed, err := updateRels([]byte(test.input), "xl/vbaProject.bin", parts, test.versions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+			if !slices.Equal(removed, test.removed) {
+				t.Errorf("removed %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
+
+func TestNewRelationshipID(t *testing.T) {
+	tests := []struct {
+		ids  []string
+		want string
+	}{
+		{nil, "rId1"},
+		{[]string{"rId1", "rId2"}, "rId3"},
+		{[]string{"rId1", "rId3"}, "rId2"},
+		{[]string{"rId2", "sig1"}, "rId1"},
+	}
+	for _, test := range tests {
+		ids := map[string]bool{}
+		for _, id := range test.ids {
+			ids[id] = true
+		}
+		if got := newRelationshipID(ids); got != test.want || !ids[got] {
+			t.Errorf("new ID next to %v is %s (marked used %t), want %s", test.ids, got, ids[got], test.want)
+		}
+	}
+}
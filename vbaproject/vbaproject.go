@@ -1,6 +1,14 @@
 package vbaproject
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coffeeforyou/vbasig/opc"
 	"github.com/coffeeforyou/vbasig/vbaproject/dirstream"
 	"github.com/coffeeforyou/vbasig/vbaproject/modulestream"
 	"github.com/coffeeforyou/vbasig/vbaproject/projectstream"
@@ -27,3 +35,81 @@ func (p *VbaProject) GetModulesWithOffset() []ModuleWithOffset {
 	}
 	return mswo
 }
+
+// Loads and parses the VBA project of an Office file (e.g. .xlsm, .xlsb, .docm, .pptm, .vsdm, .xls, .doc, .ppt)
+func LoadVbaProject(officeFilePath string) (*VbaProject, error) {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer officeFile.Close()
+	// The format is detected from the content, the extension of a renamed file may be wrong
+	return ReadVbaProject(officeFile, size, "")
+}
+
+// Parses the VBA project of an Office file read from r (size bytes), the file type may be empty (see SignDocument)
+func ReadVbaProject(r io.ReaderAt, size int64, fileType string) (*VbaProject, error) {
+	if err := checkFileType(r, fileType); err != nil {
+		return nil, err
+	}
+	if isCompoundFile(r) {
+		return ParseVbaProject(r)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return nil, err
+	}
+	p, _, err := readVbaProject(pkg)
+	return p, err
+}
+
+// Parses the VBA project of the package and returns it with the name of its part (e.g. xl/vbaProject.bin)
+func readVbaProject(pkg *opc.Package) (*VbaProject, string, error) {
+	vbaPart, err := findVbaProjectPart(pkg)
+	if err != nil {
+		return nil, "", err
+	}
+	vbaProjectFileBytes, err := pkg.ReadPart(vbaPart)
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := ParseVbaProject(bytes.NewReader(vbaProjectFileBytes))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", vbaPart, err)
+	}
+	return p, vbaPart, nil
+}
+
+// Opens a file and returns its size
+func openFile(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// Writes the file <name>-<suffix>.<ext> next to the given file and returns its path, the file is removed if write fails
+func createFileNextTo(path string, suffix string, write func(w io.Writer) error) (outPath string, err error) {
+	ext := filepath.Ext(path)
+	outPath = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), suffix, ext)
+	f, err := os.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		// Do not leave incomplete files behind
+		if err != nil {
+			os.Remove(outPath)
+		}
+	}()
+	return outPath, write(f)
+}
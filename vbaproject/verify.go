@@ -0,0 +1,273 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/pkcs7"
+	"github.com/coffeeforyou/vbasig/vbasigfile"
+)
+
+type SignatureVersion int
+
+const (
+	SignatureV1    SignatureVersion = iota // 'legacy' signature (vbaProjectSignature.bin)
+	SignatureAgile                         // agile signature (vbaProjectSignatureAgile.bin)
+	SignatureV3                            // V3 signature (vbaProjectSignatureV3.bin)
+)
+
+// All signature versions in the order they are written to the Office file
+var SignatureVersions = []SignatureVersion{SignatureV1, SignatureAgile, SignatureV3}
+
+func (v SignatureVersion) String() string {
+	switch v {
+	case SignatureV1:
+		return "V1"
+	case SignatureAgile:
+		return "Agile"
+	case SignatureV3:
+		return "V3"
+	}
+	return fmt.Sprintf("SignatureVersion(%d)", int(v))
+}
+
+func (v SignatureVersion) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v *SignatureVersion) UnmarshalText(text []byte) error {
+	for _, version := range SignatureVersions {
+		if version.String() == string(text) {
+			*v = version
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown signature version %q", text)
+}
+
+// Name of the file in the Office file containing the signature
+func (v SignatureVersion) FileName() string {
+	switch v {
+	case SignatureV1:
+		return "vbaProjectSignature.bin"
+	case SignatureAgile:
+		return "vbaProjectSignatureAgile.bin"
+	case SignatureV3:
+		return "vbaProjectSignatureV3.bin"
+	}
+	return ""
+}
+
+// Type of the relationship from vbaProject.bin to the signature part
+func (v SignatureVersion) RelationshipType() string {
+	switch v {
+	case SignatureV1:
+		return "http://schemas.microsoft.com/office/2006/relationships/vbaProjectSignature"
+	case SignatureAgile:
+		return "http://schemas.microsoft.com/office/2014/relationships/vbaProjectSignatureAgile"
+	case SignatureV3:
+		return "http://schemas.microsoft.com/office/2020/07/relationships/vbaProjectSignatureV3"
+	}
+	return ""
+}
+
+// Content type of the signature part
+func (v SignatureVersion) ContentType() string {
+	switch v {
+	case SignatureV1:
+		return "application/vnd.ms-office.vbaProjectSignature"
+	case SignatureAgile:
+		return "application/vnd.ms-office.vbaProjectSignatureAgile"
+	case SignatureV3:
+		return "application/vnd.ms-office.vbaProjectSignatureV3"
+	}
+	return ""
+}
+
+// Result of the verification of a single signature
+type SignatureVerification struct {
+	Version  SignatureVersion
+	PartName string            // name of the signature file in the Office file
+	Signer   *x509.Certificate // signing certificate, if it could be determined
+	Valid    bool
+	Reason   string // why the signature is invalid, empty if valid
+
+	Timestamp *pkcs7.SignerTimestamp // timestamp covering the signature, nil if there is none; its TSA is only verified with VerifyOptions.Roots
+	Expired   bool                   // the signing certificate has expired, the signature is still valid because of a timestamp by a trusted TSA
+}
+
+func (sv SignatureVerification) String() string {
+	signer := "unknown signer"
+	if sv.Signer != nil {
+		signer = sv.Signer.Subject.String()
+	}
+	if sv.Valid {
+		res := fmt.Sprintf("%s (%s): PASS, signed by %s", sv.Version, sv.PartName, signer)
+		if sv.Timestamp != nil {
+			res += fmt.Sprintf(", timestamped %s by %s", sv.Timestamp.Time.Format(time.RFC3339), sv.Timestamp.TSA.Subject)
+		}
+		if sv.Expired {
+			res += fmt.Sprintf(" (certificate expired %s, accepted because of the timestamp)", sv.Signer.NotAfter.Format(time.RFC3339))
+		}
+		return res
+	}
+	return fmt.Sprintf("%s (%s): FAIL, %s", sv.Version, sv.PartName, sv.Reason)
+}
+
+// Verifies all VBA signatures (V1, Agile, V3) present in an Office file (e.g. .xlsm, .xlsb, .docm, .pptm, .vsdm, .xls, .doc, .ppt)
+func VerifyVbaProject(officeFilePath string) ([]SignatureVerification, error) {
+	return VerifyVbaProjectWithOptions(officeFilePath, VerifyOptions{})
+}
+
+// Like VerifyVbaProject, with trusted roots to verify the certificate chains against
+func VerifyVbaProjectWithOptions(officeFilePath string, vo VerifyOptions) ([]SignatureVerification, error) {
+	officeFile, size, err := openFile(officeFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer officeFile.Close()
+	// The format is detected from the content, the extension of a renamed file may be wrong
+	return VerifyDocumentWithOptions(officeFile, size, "", vo)
+}
+
+// Verifies all VBA signatures of an Office file read from r (size bytes), the file type may be empty (see SignDocument)
+func VerifyDocument(r io.ReaderAt, size int64, fileType string) ([]SignatureVerification, error) {
+	return VerifyDocumentWithOptions(r, size, fileType, VerifyOptions{})
+}
+
+// Like VerifyDocument, with trusted roots to verify the certificate chains against
+func VerifyDocumentWithOptions(r io.ReaderAt, size int64, fileType string, vo VerifyOptions) ([]SignatureVerification, error) {
+	if err := checkFileType(r, fileType); err != nil {
+		return nil, err
+	}
+	if isCompoundFile(r) {
+		return verifyCompoundDocument(r, size, vo)
+	}
+	pkg, err := openPackage(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load and parse VBA project
+	vbaProject, vbaPart, err := readVbaProject(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify each signature present
+	var res []SignatureVerification
+	for _, version := range SignatureVersions {
+		partName, signatureBytes, err := readSignaturePart(pkg, vbaPart, version)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sv := verifySignature(vbaProject, version, signatureBytes, vo)
+		sv.PartName = partName
+		res = append(res, sv)
+	}
+	return res, nil
+}
+
+// Verifies a serialized signature (DigSigInfoSerialized) of the given version against the VBA project
+func VerifySignature(p *VbaProject, version SignatureVersion, signatureFile []byte) SignatureVerification {
+	return verifySignature(p, version, signatureFile, VerifyOptions{})
+}
+
+func verifySignature(p *VbaProject, version SignatureVersion, signatureFile []byte, vo VerifyOptions) SignatureVerification {
+	return verifyParsedSignature(p, version, signatureFile, vbasigfile.ParseDigSigInfoSerialized, vo)
+}
+
+// Verifies a signature, parse extracts the DigSigInfoSerialized from the signature file or stream
+func verifyParsedSignature(p *VbaProject, version SignatureVersion, signatureFile []byte, parse func([]byte) (*vbasigfile.DigSigInfoSerialized, error), vo VerifyOptions) SignatureVerification {
+	res := SignatureVerification{Version: version, PartName: version.FileName()}
+	sigInfo, err := parse(signatureFile)
+	if err != nil {
+		res.Reason = fmt.Sprintf("cannot parse signature: %v", err)
+		return res
+	}
+	p7 := sigInfo.PbSignature
+	res.Signer = p7.GetOnlySigner()
+
+	// Digest of the VBA project as stored in the signature
+	content, err := ParseSpcIndirectDataContent(p7.Content)
+	if err != nil {
+		res.Reason = fmt.Sprintf("cannot parse SpcIndirectDataContent: %v", err)
+		return res
+	}
+	var signedHash, computedHash []byte
+	switch version {
+	case SignatureV1:
+		signedHash = content.MessageDigest.Digest
+		computedHash = getHashV1(p)
+	case SignatureAgile, SignatureV3:
+		signedHash, err = ParseSigDataV1SourceHash(content.MessageDigest.Digest)
+		if err != nil {
+			res.Reason = fmt.Sprintf("cannot parse SigDataV1Serialized: %v", err)
+			return res
+		}
+		if version == SignatureAgile {
+			computedHash = p.getHashAgile()
+		} else if computedHash, err = getHashV3(p); err != nil {
+			res.Reason = fmt.Sprintf("cannot compute project hash: %v", err)
+			return res
+		}
+	default:
+		res.Reason = fmt.Sprintf("unknown signature version %s", version)
+		return res
+	}
+	if !bytes.Equal(signedHash, computedHash) {
+		res.Reason = fmt.Sprintf("project hash mismatch (signed %X, computed %X)", signedHash, computedHash)
+		return res
+	}
+
+	// Signature over the content
+	if err := p7.Verify(); err != nil {
+		res.Reason = fmt.Sprintf("PKCS#7 signature invalid: %v", err)
+		return res
+	}
+
+	// A timestamp proves that the signature was created before, the signing certificate has to be valid at that time only.
+	// Without trusted roots the TSA is not verified, anyone could have issued the token, so the certificate must be valid now.
+	timestamps, err := p7.VerifyTimestamps(vo.Roots)
+	if err != nil {
+		res.Reason = fmt.Sprintf("timestamp invalid: %v", err)
+		return res
+	}
+	now := vo.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	signingTime := now
+	if len(timestamps) == 1 && timestamps[0] != nil {
+		res.Timestamp = timestamps[0]
+		if vo.Roots != nil {
+			signingTime = res.Timestamp.Time
+		}
+	}
+	if res.Signer == nil {
+		res.Reason = "signature must have exactly one signer"
+		return res
+	}
+	if signingTime.Before(res.Signer.NotBefore) || signingTime.After(res.Signer.NotAfter) {
+		res.Reason = fmt.Sprintf("signing certificate not valid at %s (valid from %s to %s)", signingTime.Format(time.RFC3339),
+			res.Signer.NotBefore.Format(time.RFC3339), res.Signer.NotAfter.Format(time.RFC3339))
+		return res
+	}
+	if vo.Roots != nil {
+		if err := p7.VerifyWithChainAtTime(vo.Roots, signingTime); err != nil {
+			res.Reason = fmt.Sprintf("certificate chain invalid: %v", err)
+			return res
+		}
+	}
+	res.Expired = now.After(res.Signer.NotAfter)
+	res.Valid = true
+	return res
+}
@@ -0,0 +1,55 @@
+package vbaproject
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestVerifyDocumentWithOptions(t *testing.T) {
+	doc := testDocument(t)
+	id := testIdentity(t)
+	var signed bytes.Buffer
+	if err := SignDocument(&signed, bytes.NewReader(doc), int64(len(doc)), "", id, SignOptions{IncludeV1: true, IncludeAgile: true, IncludeV3: true}); err != nil {
+		t.Fatal(err)
+	}
+	pool := func(certs ...*x509.Certificate) *x509.CertPool {
+		res := x509.NewCertPool()
+		for _, c := range certs {
+			res.AddCert(c)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name   string
+		doc    []byte
+		vo     VerifyOptions
+		reason string // prefix of the reason of each signature, empty if valid
+	}{
+		{"valid", signed.Bytes(), VerifyOptions{}, ""},
+		{"trusted root", signed.Bytes(), VerifyOptions{Roots: pool(id.Certificate)}, ""},
+		{"untrusted root", signed.Bytes(), VerifyOptions{Roots: pool(testIdentityNamed(t, "other root").Certificate)}, "certificate chain invalid"},
+		{"module changed", tamperModule(t, signed.Bytes()), VerifyOptions{}, "project hash mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := VerifyDocumentWithOptions(bytes.NewReader(test.doc), int64(len(test.doc)), ".xlsm", test.vo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 3 {
+				t.Fatalf("got %d signatures, want 3", len(results))
+			}
+			for i, r := range results {
+				if r.Version != SignatureVersions[i] {
+					t.Errorf("got %s signature at %d, want %s", r.Version, i, SignatureVersions[i])
+				}
+				if r.Valid != (test.reason == "") || !strings.HasPrefix(r.Reason, test.reason) {
+					t.Errorf("%s, want reason %q", r, test.reason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package vbaproject
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// XML part (relationships or content types) edited in place: elements are inserted and removed at their byte offsets,
+// everything else (declaration, namespaces, unknown attributes, order, whitespace) is kept as is
+type xmlPart struct {
+	data     []byte
+	root     xml.Name     // name of the root element as written, Space is the prefix
+	children []xmlElement // child elements of the root element
+	edits    []xmlEdit
+
+	insertAt    int    // offset for new elements, after the last child element
+	selfClosing bool   // the root element has no end tag, insertAt is the offset of its "/>"
+	indent      []byte // whitespace before the first child element, written before each new element
+}
+
+// Child element of the root element
+type xmlElement struct {
+	start, end int
+	name       string            // local name
+	attrs      map[string]string // unprefixed attributes by name
+}
+
+type xmlEdit struct {
+	start, end int
+	text       []byte
+}
+
+// Parses the part and checks the local name of its root element
+func parseXMLPart(data []byte, rootName string) (*xmlPart, error) {
+	p := &xmlPart{data: data}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	rootEnd := -1
+	var open []xml.Name // elements not closed yet, RawToken does not check that end elements match
+	var lastWhitespace []byte
+	var current *xmlElement
+	for {
+		offset := int(d.InputOffset())
+		token, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			if len(open) > 0 {
+				return nil, fmt.Errorf("element %s not closed", open[len(open)-1].Local)
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			open = append(open, t.Name)
+			switch len(open) {
+			case 1:
+				if rootEnd >= 0 {
+					return nil, fmt.Errorf("second root element %s", t.Name.Local)
+				}
+				if t.Name.Local != rootName {
+					return nil, fmt.Errorf("root element %s, expected %s", t.Name.Local, rootName)
+				}
+				p.root = t.Name
+				p.insertAt = int(d.InputOffset())
+				rootEnd = p.insertAt
+			case 2:
+				if len(p.children) == 0 {
+					p.indent = lastWhitespace
+				}
+				current = &xmlElement{start: offset, name: t.Name.Local, attrs: map[string]string{}}
+				for _, a := range t.Attr {
+					if a.Name.Space == "" {
+						current.attrs[a.Name.Local] = a.Value
+					}
+				}
+			}
+		case xml.EndElement:
+			if len(open) == 0 {
+				return nil, fmt.Errorf("end element %s without start element", t.Name.Local)
+			}
+			if t.Name != open[len(open)-1] {
+				return nil, fmt.Errorf("element %s closed by %s", open[len(open)-1].Local, t.Name.Local)
+			}
+			switch len(open) {
+			case 1:
+				// A self-closing root element returns its end element without further input
+				p.selfClosing = int(d.InputOffset()) == rootEnd
+				if p.selfClosing {
+					p.insertAt = rootEnd - len("/>")
+				}
+			case 2:
+				current.end = int(d.InputOffset())
+				p.children = append(p.children, *current)
+				p.insertAt = current.end
+			}
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if len(open) == 1 {
+				// Raw bytes, the decoder turns CRLF line breaks into LF
+				lastWhitespace = data[offset:int(d.InputOffset())]
+			}
+		}
+	}
+	if rootEnd < 0 {
+		return nil, fmt.Errorf("no %s element", rootName)
+	}
+	if len(bytes.TrimSpace(p.indent)) > 0 {
+		p.indent = nil
+	}
+	return p, nil
+}
+
+// Child elements with the given local name
+func (p *xmlPart) elements(name string) []xmlElement {
+	var res []xmlElement
+	for _, e := range p.children {
+		if e.name == name {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Removes an element together with the whitespace before it
+func (p *xmlPart) remove(e xmlElement) {
+	start := e.start
+	for start > 0 && isXMLWhitespace(p.data[start-1]) {
+		start--
+	}
+	p.edits = append(p.edits, xmlEdit{start: start, end: e.end})
+}
+
+// Inserts an element with the given attributes (name and value pairs) after the last child element,
+// with the namespace prefix of the root element
+func (p *xmlPart) insert(name string, attrs ...[2]string) {
+	var b bytes.Buffer
+	b.Write(p.indent)
+	b.WriteString("<" + p.prefixed(name))
+	for _, a := range attrs {
+		b.WriteString(" " + a[0] + `="`)
+		xml.EscapeText(&b, []byte(a[1]))
+		b.WriteString(`"`)
+	}
+	b.WriteString("/>")
+	p.edits = append(p.edits, xmlEdit{start: p.insertAt, end: p.insertAt, text: b.Bytes()})
+}
+
+func (p *xmlPart) prefixed(name string) string {
+	if p.root.Space == "" {
+		return name
+	}
+	return p.root.Space + ":" + name
+}
+
+// Returns the edited part, unchanged bytes if nothing was edited
+func (p *xmlPart) bytes() []byte {
+	if len(p.edits) == 0 {
+		return p.data
+	}
+	edits := slices.Clone(p.edits)
+	if p.selfClosing && len(edits) > 0 {
+		// New elements need an end tag of the root element
+		edits = slices.Insert(edits, 0, xmlEdit{start: p.insertAt, end: p.insertAt, text: []byte(">")})
+		edits = append(edits, xmlEdit{start: p.insertAt, end: p.insertAt + len("/>"), text: []byte("</" + p.prefixed(p.root.Local) + ">")})
+	}
+	// Apply in order of the offsets, inserted elements keep their order
+	slices.SortStableFunc(edits, func(a, b xmlEdit) int { return a.start - b.start })
+	var res []byte
+	offset := 0
+	for _, e := range edits {
+		if e.start < offset {
+			continue
+		}
+		res = append(res, p.data[offset:e.start]...)
+		res = append(res, e.text...)
+		offset = e.end
+	}
+	return append(res, p.data[offset:]...)
+}
+
+func isXMLWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,83 @@
+package vbaproject
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coffeeforyou/vbasig/opc"
+)
+
+func TestXMLPartEdit(t *testing.T) {
+	// xl/_rels/workbook.xml.rels of the test document, the child elements are separated by a space
+	doc := testDocument(t)
+	pkg, err := opc.Open(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkg.ReadPart("xl/_rels/workbook.xml.rels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workbookRels := string(data)
+	theme := ` <Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/theme" Target="theme/theme1.xml"/>`
+	if !strings.Contains(workbookRels, theme) || !strings.HasSuffix(workbookRels, "/></Relationships>") {
+		t.Fatalf("unexpected xl/_rels/workbook.xml.rels of the test document: %q", workbookRels)
+	}
+	added := ` <Relationship Id="rId6" Type="urn:test" Target="a&amp;b.xml"/>`
+
+	tests := []struct {
+		name   string
+		input  string
+		remove string // Id of the element to remove
+		insert bool
+		want   string
+	}{
+		{"saved by Excel, unchanged", workbookRels, "", false, workbookRels},
+		{"saved by Excel, removed", workbookRels, "rId3", false, strings.Replace(workbookRels, theme, "", 1)},
+		{"saved by Excel, inserted", workbookRels, "", true, strings.TrimSuffix(workbookRels, "</Relationships>") + added + "</Relationships>"},
+		{"saved by Excel, removed and inserted", workbookRels, "rId3", true,
+			strings.TrimSuffix(strings.Replace(workbookRels, theme, "", 1), "</Relationships>") + added + "</Relationships>"},
+		{"removed last element", "<Relationships>\r\n\t<Relationship Id=\"rId1\"/>\r\n\t<Relationship Id=\"rId3\"/>\r\n</Relationships>", "rId3", false,
+			"<Relationships>\r\n\t<Relationship Id=\"rId1\"/>\r\n</Relationships>"},
+		{"self-closing root element", "\xEF\xBB\xBF<Relationships/>", "", true, "\xEF\xBB\xBF<Relationships>" + added[1:] + "</Relationships>"},
+		{"empty root element", "<Relationships>\r\n</Relationships>", "", true, "<Relationships>" + added[1:] + "\r\n</Relationships>"},
+		{"comment and unknown children", "<Relationships><!-- rId3 --><x:Ext xmlns:x=\"urn:x\"><Relationship Id=\"rId3\"/></x:Ext></Relationships>", "rId3", true,
+			"<Relationships><!-- rId3 --><x:Ext xmlns:x=\"urn:x\"><Relationship Id=\"rId3\"/></x:Ext>" + added[1:] + "</Relationships>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			part, err := parseXMLPart([]byte(test.input), "Relationships")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range part.elements("Relationship") {
+				if test.remove != "" && e.attrs["Id"] == test.remove {
+					part.remove(e)
+				}
+			}
+			if test.insert {
+				part.insert("Relationship", [2]string{"Id", "rId6"}, [2]string{"Type", "urn:test"}, [2]string{"Target", "a&b.xml"})
+			}
+			if got := string(part.bytes()); got != test.want {
+				t.Errorf("got\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseXMLPartInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"<?xml version=\"1.0\"?>\r\n",
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"<Relationships><Relationship></Relationships>",
+		"<Relationships>",
+		"</Relationships>",
+		"<Relationships/><Relationships/>",
+	} {
+		if _, err := parseXMLPart([]byte(input), "Relationships"); err == nil {
+			t.Errorf("%q accepted", input)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package vbasigfile
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// In binary Office files (.xls, .doc), the signatures are stored in the streams \x05DigitalSignature (V1),
+// \x05DigitalSignatureEx (agile) and \x05DigitalSignatureExt (V3) of the VBA project storage, wrapped in a
+// DigSigBlob (Excel) or WordSigBlob (Word). The offsets in the DigSigInfoSerialized header are relative to the
+// start of the DigSigBlob or the cbSigInfo field of the WordSigBlob, i.e. the signature starts at offset 44.
+
+const serializedPointer = 8 // offset of the DigSigInfoSerialized in the blob (after cb and serializedPointer)
+
+// Serializes the signature as DigSigBlob: cb, serializedPointer, DigSigInfoSerialized, padding to a multiple of 4 bytes
+func (sigBlob *DigSigInfoSerialized) SerializeDigSigBlob() []byte {
+	info := sigBlob.Serialize()
+	buf := make([]byte, 8, 8+len(info)+3)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(info)))
+	binary.LittleEndian.PutUint32(buf[4:], serializedPointer)
+	buf = append(buf, info...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Serializes the signature as WordSigBlob: cch, cbSigInfo, serializedPointer, DigSigInfoSerialized, padding to a multiple of 2 bytes.
+// cch is the size of the structure after the cch field in 16-bit units.
+func (sigBlob *DigSigInfoSerialized) SerializeWordSigBlob() ([]byte, error) {
+	info := sigBlob.Serialize()
+	buf := make([]byte, 10, 10+len(info)+1)
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(info)))
+	binary.LittleEndian.PutUint32(buf[6:], serializedPointer)
+	buf = append(buf, info...)
+	if len(buf)%2 != 0 {
+		buf = append(buf, 0)
+	}
+	cch := (len(buf) - 2) / 2
+	if cch > 0xFFFF {
+		return nil, fmt.Errorf("signature too large for WordSigBlob (%d bytes)", len(info))
+	}
+	binary.LittleEndian.PutUint16(buf[0:], uint16(cch))
+	return buf, nil
+}
+
+// Parses the DigSigInfoSerialized contained in a DigSigBlob (signature stream of binary Excel files)
+func ParseDigSigBlob(data []byte) (*DigSigInfoSerialized, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("DigSigBlob truncated: %d bytes", len(data))
+	}
+	return parseSigBlob(data[8:], binary.LittleEndian.Uint32(data[0:]), binary.LittleEndian.Uint32(data[4:]))
+}
+
+// Parses the DigSigInfoSerialized contained in a WordSigBlob (signature stream of binary Word files)
+func ParseWordSigBlob(data []byte) (*DigSigInfoSerialized, error) {
+	if len(data) < 10 {
+		return nil, fmt.Errorf("WordSigBlob truncated: %d bytes", len(data))
+	}
+	return parseSigBlob(data[10:], binary.LittleEndian.Uint32(data[2:]), binary.LittleEndian.Uint32(data[6:]))
+}
+
+func parseSigBlob(data []byte, cbSigInfo uint32, pointer uint32) (*DigSigInfoSerialized, error) {
+	if pointer != serializedPointer {
+		return nil, fmt.Errorf("unexpected serializedPointer %d", pointer)
+	}
+	if uint64(cbSigInfo) > uint64(len(data)) {
+		return nil, fmt.Errorf("signature blob truncated: %d bytes, header requires %d", len(data), cbSigInfo)
+	}
+	return ParseDigSigInfoSerialized(data[:cbSigInfo])
+}
@@ -0,0 +1,164 @@
+package vbasigfile
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coffeeforyou/vbasig/pkcs7"
+)
+
+// Signature of 6 bytes and certificate store of 3 bytes, so that both blobs need padding
+func testSigInfo() *DigSigInfoSerialized {
+	info := &DigSigInfoSerialized{
+		DigSigInfoSerializedHeader: DigSigInfoSerializedHeader{SignatureOffset: 44},
+		RgchProjectNameBuffer:      []byte{0, 0},
+		RgchTimestampBuffer:        []byte{0, 0},
+	}
+	info.ReplaceSigBuffer([]byte{0x30, 0x01, 0x02, 0x03, 0x04, 0x05})
+	info.ReplaceCertStore([]byte{0xC1, 0xC2, 0xC3})
+	return info
+}
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Header fields of DigSigInfoSerialized: cbSignature 6, signatureOffset 44, cbSigningCertStore 3, certStoreOffset 50,
+// cbProjectName 0, projectNameOffset 53, fTimestamp 0, cbTimestampUrl 0, timestampUrlOffset 55
+const testSigInfoBytes = `
+	06000000 2C000000 03000000 32000000 00000000 35000000 00000000 00000000 37000000
+	300102030405 C1C2C3 0000 0000`
+
+func TestSerializeDigSigBlob(t *testing.T) {
+	// cb 49, serializedPointer 8, DigSigInfoSerialized, padding to 60 bytes
+	want := unhex("31000000 08000000" + testSigInfoBytes + "000000")
+	got := testSigInfo().SerializeDigSigBlob()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("DigSigBlob\n%X\nwant\n%X", got, want)
+	}
+	checkOffsets(t, got, 0)
+}
+
+func TestSerializeWordSigBlob(t *testing.T) {
+	// cch 29 (58 bytes after cch), cbSigInfo 49, serializedPointer 8, DigSigInfoSerialized, padding to 60 bytes
+	want := unhex("1D00 31000000 08000000" + testSigInfoBytes + "00")
+	got, err := testSigInfo().SerializeWordSigBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("WordSigBlob\n%X\nwant\n%X", got, want)
+	}
+	checkOffsets(t, got, 2)
+}
+
+// The offsets of the header are relative to base, the start of the DigSigBlob or the cbSigInfo field of the WordSigBlob
+func checkOffsets(t *testing.T, blob []byte, base int) {
+	t.Helper()
+	info := testSigInfo()
+	header := blob[base+serializedPointer:]
+	for _, field := range []struct {
+		name           string
+		sizeAt, offset int
+		want           []byte
+	}{
+		{"signature", 0, 4, info.PbSignatureBuffer},
+		{"certificate store", 8, 12, info.PbSigningCertStoreBuffer},
+	} {
+		size := int(binary.LittleEndian.Uint32(header[field.sizeAt:]))
+		offset := base + int(binary.LittleEndian.Uint32(header[field.offset:]))
+		if got := blob[offset : offset+size]; !bytes.Equal(got, field.want) {
+			t.Errorf("%s at offset %d is %X, want %X", field.name, offset, got, field.want)
+		}
+	}
+	for _, field := range []struct {
+		name   string
+		offset int
+	}{{"project name", 20}, {"timestamp URL", 32}} {
+		offset := base + int(binary.LittleEndian.Uint32(header[field.offset:]))
+		if got := blob[offset : offset+2]; !bytes.Equal(got, []byte{0, 0}) {
+			t.Errorf("%s at offset %d is %X, want empty string", field.name, offset, got)
+		}
+	}
+}
+
+func TestWordSigBlobTooLarge(t *testing.T) {
+	info := testSigInfo()
+	info.ReplaceSigBuffer(make([]byte, 0x20000))
+	if _, err := info.SerializeWordSigBlob(); err == nil {
+		t.Fatal("signature larger than cch allows accepted")
+	}
+}
+
+func TestParseSigBlob(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vbasig test signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := pkcs7.NewSignedData([]byte("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewDigSigInfoSerialized(signature, *cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wordBlob, err := info.SerializeWordSigBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		blob  []byte
+		parse func([]byte) (*DigSigInfoSerialized, error)
+	}{
+		{"DigSigBlob", info.SerializeDigSigBlob(), ParseDigSigBlob},
+		{"WordSigBlob", wordBlob, ParseWordSigBlob},
+	} {
+		parsed, err := test.parse(test.blob)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(parsed.Serialize(), info.Serialize()) {
+			t.Errorf("%s: parsed DigSigInfoSerialized differs", test.name)
+		}
+		if _, err := test.parse(test.blob[:len(test.blob)/2]); err == nil {
+			t.Errorf("%s: truncated blob accepted", test.name)
+		}
+	}
+}
@@ -82,6 +82,9 @@ func ParseDigSigInfoSerialized(data []byte) (*DigSigInfoSerialized, error) {
 	// Now extract variable-length fields based on the offsets and sizes
 	// Start with offset of 36 (=header size). Offsets in structure might be inaccurate, since based on representation in memory and depending on Office file type.
 	var offset uint32 = 36
+	if uint64(offset)+uint64(sigBlob.CbSignature)+uint64(sigBlob.CbSigningCertStore)+4 > uint64(len(data)) {
+		return nil, fmt.Errorf("DigSigInfoSerialized truncated: %d bytes, header requires %d", len(data), uint64(offset)+uint64(sigBlob.CbSignature)+uint64(sigBlob.CbSigningCertStore)+4)
+	}
 	// SignatureBuffer
 	sigBlob.PbSignatureBuffer = data[offset : offset+sigBlob.CbSignature]
 	sigBlob.PbSignature, err = pkcs7.Parse(sigBlob.PbSignatureBuffer)